@@ -36,13 +36,14 @@ func createAdmin(c *gin.Context) {
 }
 
 // @Summary Discard a product request
-// @Description Discard a product request by the admin user.
+// @Description Discard a product request by the admin user. The request is cancelled, unless it is already awarded or expired.
 // @Accept json
 // @Produce json
 // @Param id path int true "Product ID"
 // @Security ApiKeyAuth
 // @Success 204 "No Content"
 // @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Router /products/{id}/discard [delete]
 func discardProductRequest(c *gin.Context) {
 	productID := c.Param("id")
@@ -66,9 +67,21 @@ func discardProductRequest(c *gin.Context) {
 		return
 	}
 
+	adminID, _ := extractSellerIDFromToken(c)
+
+	// Discarding goes through the lifecycle like any other cancellation
+	tx := db.Begin()
+	if product.Status != Cancelled {
+		if err := transitionProduct(tx, &product, Cancelled, adminID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Set is_discarded to true
-	product.IsDiscarded = true
-	db.Save(&product)
+	tx.Model(&product).Update("is_discarded", true)
+	tx.Commit()
 
 	c.Status(http.StatusNoContent)
 }
@@ -104,9 +117,29 @@ func approveProductRequest(c *gin.Context) {
 		return
 	}
 
-	// Set is_discarded to true
-	product.IsDiscarded = false
-	db.Save(&product)
+	adminID, _ := extractSellerIDFromToken(c)
+
+	tx := db.Begin()
+
+	// Approving a request under review opens it for offers
+	opened := false
+	if product.Status == PendingReview {
+		if err := transitionProduct(tx, &product, Open, adminID); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		opened = true
+	}
+
+	// Set is_discarded to false
+	tx.Model(&product).Update("is_discarded", false)
+	tx.Commit()
+
+	// Sellers only hear about a request once it takes offers
+	if opened {
+		announceProduct(db, &product)
+	}
 
 	c.Status(http.StatusNoContent)
 }
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDiscardCancelsProduct(t *testing.T) {
+	setupTestDB(t)
+
+	open := Product{Title: "Open", UserID: 1, Status: Open}
+	awarded := Product{Title: "Awarded", UserID: 1, Status: Awarded}
+	mustCreate(t, &open)
+	mustCreate(t, &awarded)
+	admin := &Token{UserID: 9, IsAdmin: true}
+
+	w := call(discardProductRequest, http.MethodDelete, idParam(open.ID), nil, admin)
+	expectStatus(t, w, http.StatusNoContent)
+	w = call(discardProductRequest, http.MethodDelete, idParam(awarded.ID), nil, admin)
+	expectStatus(t, w, http.StatusConflict)
+
+	var stored Product
+	db.Where("id = ?", open.ID).First(&stored)
+	if stored.Status != Cancelled || !stored.IsDiscarded {
+		t.Errorf("discarded product is %s with is_discarded %v, want cancelled and discarded", stored.Status, stored.IsDiscarded)
+	}
+
+	var transitions int
+	db.Model(&ProductTransition{}).Where("product_id = ? AND actor_id = ?", open.ID, admin.UserID).Count(&transitions)
+	if transitions != 1 {
+		t.Errorf("got %d transitions by the admin, want 1", transitions)
+	}
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// defaultDigestMinutes is how often alerts are sent to the external channels
+// of sellers who did not choose an interval.
+const defaultDigestMinutes = 60
+
+// AlertSettings says where a seller's new-request alerts go besides the
+// in-app inbox. Alerts are batched into one digest per DigestMinutes, so a
+// burst of new requests does not flood the seller's mailbox or webhook.
+type AlertSettings struct {
+	ID            uint       `json:"-" gorm:"primary_key"`
+	SellerID      uint       `json:"seller_id" gorm:"unique_index"`
+	Email         string     `json:"email,omitempty"`
+	WebhookURL    string     `json:"webhook_url,omitempty"`
+	DigestMinutes int        `json:"digest_minutes"`
+	LastDigestAt  *time.Time `json:"last_digest_at,omitempty"`
+}
+
+// Alert tells a seller about a product request matching their interests.
+// SentAt is set once the alert went out in a digest.
+type Alert struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	SellerID  uint       `json:"seller_id" gorm:"unique_index:idx_alert_seller_product"`
+	ProductID uint       `json:"product_id" gorm:"unique_index:idx_alert_seller_product"`
+	Title     string     `json:"title"`
+	CreatedAt time.Time  `json:"created_at"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
+}
+
+// AlertChannel delivers a digest of alerts outside the application.
+type AlertChannel interface {
+	// Configured reports whether settings name a destination on this channel.
+	Configured(settings *AlertSettings) bool
+	Send(settings *AlertSettings, alerts []Alert) error
+}
+
+// alertChannels are the channels digests are sent through. Run sets them up
+// from the environment; see newAlertChannels.
+var alertChannels = []AlertChannel{&webhookChannel{client: webhookClient()}}
+
+// newAlertChannels picks the alert channels from the environment. Webhooks
+// are always available; email is sent through SMTP_ADDR (host:port) from
+// SMTP_FROM, authenticating with SMTP_USERNAME and SMTP_PASSWORD when set.
+func newAlertChannels() []AlertChannel {
+	channels := []AlertChannel{&webhookChannel{client: webhookClient()}}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		channels = append(channels, &emailChannel{
+			addr:     addr,
+			from:     os.Getenv("SMTP_FROM"),
+			username: os.Getenv("SMTP_USERNAME"),
+			password: os.Getenv("SMTP_PASSWORD"),
+		})
+	}
+	return channels
+}
+
+// queueAlert puts an alert about product in the seller's inbox and queues it
+// for the next digest. A seller already alerted about product is skipped.
+func queueAlert(tx *gorm.DB, sellerID uint, product *Product) error {
+	var count int
+	tx.Model(&Alert{}).Where("seller_id = ? AND product_id = ?", sellerID, product.ID).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	alert := Alert{SellerID: sellerID, ProductID: product.ID, Title: product.Title, CreatedAt: clock.Now()}
+	if err := tx.Create(&alert).Error; err != nil {
+		return err
+	}
+	return notify(tx, sellerID, NotifyNewRequest, product.ID, nil,
+		fmt.Sprintf("A new product request matches your interests: %s", product.Title))
+}
+
+// cgnatRange is the shared address space of carrier-grade NAT, which
+// net.IP does not count as private.
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP reports whether ip is an address on the public internet, as
+// opposed to loopback, private, link-local (including the cloud metadata
+// address 169.254.169.254) and other special ranges.
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || cgnatRange.Contains(ip))
+}
+
+// checkWebhookURL refuses webhook URLs that are not http(s) or whose host
+// resolves to an address that is not public, so sellers cannot make the
+// server call into its own network.
+func checkWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("webhook_url must be an http or https URL")
+	}
+
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return fmt.Errorf("webhook_url host cannot be resolved")
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("webhook_url must point to a public address")
+		}
+	}
+	return nil
+}
+
+// guardDial refuses connections to addresses that are not public. It runs
+// after name resolution, so a host that resolves differently than when the
+// URL was checked, or a redirect, cannot reach the internal network either.
+func guardDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to connect to %s", host)
+	}
+	return nil
+}
+
+// webhookClient returns the HTTP client webhooks are sent with. It only
+// connects to public addresses and gives up after ten seconds.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: guardDial}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// webhookChannel posts digests as JSON to the seller's webhook URL.
+type webhookChannel struct {
+	client *http.Client
+}
+
+func (w *webhookChannel) Configured(settings *AlertSettings) bool {
+	return settings.WebhookURL != ""
+}
+
+func (w *webhookChannel) Send(settings *AlertSettings, alerts []Alert) error {
+	body, err := json.Marshal(gin.H{"seller_id": settings.SellerID, "alerts": alerts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.client.Post(settings.WebhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// emailChannel mails digests as plain text through an SMTP relay.
+type emailChannel struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func (e *emailChannel) Configured(settings *AlertSettings) bool {
+	return settings.Email != ""
+}
+
+func (e *emailChannel) Send(settings *AlertSettings, alerts []Alert) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %d new product requests\r\n\r\n", e.from, settings.Email, len(alerts))
+	for _, alert := range alerts {
+		fmt.Fprintf(&body, "#%d %s\r\n", alert.ProductID, alert.Title)
+	}
+
+	var auth smtp.Auth
+	if e.username != "" {
+		auth = smtp.PlainAuth("", e.username, e.password, strings.Split(e.addr, ":")[0])
+	}
+	return smtp.SendMail(e.addr, auth, e.from, []string{settings.Email}, []byte(body.String()))
+}
+
+// sendAlertDigests sends every seller whose digest interval has passed the
+// alerts queued since their last digest. Sellers without an external channel
+// only get the in-app notifications, so their alerts are marked sent right
+// away. A failed delivery is retried on a later tick.
+func (s *scheduler) sendAlertDigests(now time.Time) {
+	var sellerIDs []uint
+	if err := s.db.Model(&Alert{}).Where("sent_at IS NULL").Pluck("DISTINCT seller_id", &sellerIDs).Error; err != nil {
+		log.Println("scheduler: failed to load alerts:", err)
+		return
+	}
+
+	for _, sellerID := range sellerIDs {
+		var settings AlertSettings
+		if s.db.Where("seller_id = ?", sellerID).First(&settings).RecordNotFound() {
+			settings = AlertSettings{SellerID: sellerID, DigestMinutes: defaultDigestMinutes}
+		}
+
+		var channels []AlertChannel
+		for _, channel := range alertChannels {
+			if channel.Configured(&settings) {
+				channels = append(channels, channel)
+			}
+		}
+
+		if len(channels) > 0 && settings.LastDigestAt != nil &&
+			now.Before(settings.LastDigestAt.Add(time.Duration(settings.DigestMinutes)*time.Minute)) {
+			continue
+		}
+
+		var alerts []Alert
+		s.db.Where("seller_id = ? AND sent_at IS NULL", sellerID).Order("id").Find(&alerts)
+		if len(alerts) == 0 {
+			continue
+		}
+
+		failed := false
+		for _, channel := range channels {
+			if err := channel.Send(&settings, alerts); err != nil {
+				log.Printf("scheduler: failed to send alerts to seller %d: %v", sellerID, err)
+				failed = true
+			}
+		}
+		if failed {
+			continue
+		}
+
+		ids := make([]uint, len(alerts))
+		for i, alert := range alerts {
+			ids[i] = alert.ID
+		}
+		s.db.Model(&Alert{}).Where("id IN (?)", ids).Update("sent_at", now)
+		if len(channels) > 0 && settings.ID != 0 {
+			s.db.Model(&settings).Update("last_digest_at", now)
+		}
+	}
+}
+
+// @Summary Get my alert settings
+// @Description Get where the current seller's new-request alerts are sent and how often.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} AlertSettings
+// @Router /profile/alert-settings [get]
+func getAlertSettings(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var settings AlertSettings
+	if db.Where("seller_id = ?", sellerID).First(&settings).RecordNotFound() {
+		settings = AlertSettings{SellerID: sellerID, DigestMinutes: defaultDigestMinutes}
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+type alertSettingsRequest struct {
+	Email         string `json:"email"`
+	WebhookURL    string `json:"webhook_url"`
+	DigestMinutes int    `json:"digest_minutes"`
+}
+
+// @Summary Set my alert settings
+// @Description Set the email address and webhook URL new-request alerts are sent to, and the digest interval in minutes (60 by default). Webhooks must point to a public address. Leave both empty to only get in-app notifications.
+// @Accept json
+// @Produce json
+// @Param input body alertSettingsRequest true "Alert settings"
+// @Security ApiKeyAuth
+// @Success 200 {object} AlertSettings
+// @Router /profile/alert-settings [put]
+func setAlertSettings(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input alertSettingsRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.DigestMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "digest_minutes must not be negative"})
+		return
+	}
+	if input.DigestMinutes == 0 {
+		input.DigestMinutes = defaultDigestMinutes
+	}
+	if input.Email != "" && !strings.Contains(input.Email, "@") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid email address"})
+		return
+	}
+	if input.WebhookURL != "" {
+		if err := checkWebhookURL(input.WebhookURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var settings AlertSettings
+	db.Where(AlertSettings{SellerID: sellerID}).FirstOrInit(&settings)
+	settings.Email = input.Email
+	settings.WebhookURL = input.WebhookURL
+	settings.DigestMinutes = input.DigestMinutes
+	db.Save(&settings)
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetAlertSettingsRefusesInternalWebhooks(t *testing.T) {
+	setupTestDB(t)
+
+	for _, target := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://10.0.0.5/hook",
+		"http://192.168.1.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"http://100.64.0.1/hook",
+		"ftp://93.184.216.34/hook",
+	} {
+		w := call(setAlertSettings, http.MethodPut, nil, alertSettingsRequest{WebhookURL: target}, &Token{UserID: 2})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", target, w.Code)
+		}
+	}
+
+	w := call(setAlertSettings, http.MethodPut, nil, alertSettingsRequest{WebhookURL: "https://93.184.216.34/hook"}, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusOK)
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	hit := false
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		hit = true
+	}))
+	defer server.Close()
+
+	channel := &webhookChannel{client: webhookClient()}
+	err := channel.Send(&AlertSettings{SellerID: 2, WebhookURL: server.URL}, []Alert{{ProductID: 1, Title: "Steel"}})
+	if err == nil || hit {
+		t.Errorf("webhook to %s was delivered, want it refused", server.URL)
+	}
+}
+
+// recordingChannel collects the digests it is asked to send.
+type recordingChannel struct {
+	digests [][]Alert
+}
+
+func (r *recordingChannel) Configured(settings *AlertSettings) bool {
+	return settings.WebhookURL != ""
+}
+
+func (r *recordingChannel) Send(_ *AlertSettings, alerts []Alert) error {
+	r.digests = append(r.digests, alerts)
+	return nil
+}
+
+func TestAlertDigestsAreBatched(t *testing.T) {
+	fake := setupTestDB(t)
+	channel := &recordingChannel{}
+	previous := alertChannels
+	alertChannels = []AlertChannel{channel}
+	defer func() { alertChannels = previous }()
+
+	mustCreate(t, &AlertSettings{SellerID: 2, WebhookURL: "https://example.com/hook", DigestMinutes: 60})
+	s := newScheduler(db, fake, time.Second)
+
+	queue := func(id uint) {
+		if err := queueAlert(db, 2, &Product{ID: id, Title: "Request"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queue(1)
+	queue(1)
+	s.sendAlertDigests(fake.Now())
+	queue(2)
+	queue(3)
+	fake.Advance(30 * time.Minute)
+	s.sendAlertDigests(fake.Now())
+	fake.Advance(31 * time.Minute)
+	s.sendAlertDigests(fake.Now())
+
+	if len(channel.digests) != 2 || len(channel.digests[0]) != 1 || len(channel.digests[1]) != 2 {
+		t.Fatalf("digests = %v, want one alert and then two", channel.digests)
+	}
+
+	var notifications int
+	db.Model(&Notification{}).Where("user_id = ? AND kind = ?", 2, NotifyNewRequest).Count(&notifications)
+	if notifications != 3 {
+		t.Errorf("got %d in-app notifications, want 3", notifications)
+	}
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// maxAttachmentSize caps a single file attached to a product or bid.
+const maxAttachmentSize = 20 << 20
+
+// allowedUploadTypes are the content types uploads may sniff as. Anything
+// the sniffer cannot tell apart from an executable is refused.
+var allowedUploadTypes = map[string]bool{
+	"application/pdf":           true,
+	"application/zip":           true,
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"image/bmp":                 true,
+	"text/plain; charset=utf-8": true,
+}
+
+// Blob records a stored file by the SHA-256 of its content. Attachments with
+// the same content share one blob.
+type Blob struct {
+	ID          uint      `json:"id" gorm:"primary_key"`
+	Hash        string    `json:"hash" gorm:"unique_index"`
+	Size        int       `json:"size"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Attachment is a file attached to a product, or to a bid when BidID is set.
+type Attachment struct {
+	ID          uint      `json:"id" gorm:"primary_key"`
+	ProductID   uint      `json:"product_id" gorm:"index"`
+	BidID       *uint     `json:"bid_id,omitempty" gorm:"index"`
+	UploaderID  uint      `json:"uploader_id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Hash        string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// sniffUpload checks the size of an upload and returns the content type
+// sniffed from its bytes. The type the client claims is never trusted.
+func sniffUpload(fileName string, data []byte, limit int) (string, error) {
+	if len(data) == 0 {
+		return "", fmt.Errorf("file %q is empty", fileName)
+	}
+	if len(data) > limit {
+		return "", fmt.Errorf("file %q is larger than %d bytes", fileName, limit)
+	}
+
+	contentType := http.DetectContentType(data)
+	if !allowedUploadTypes[contentType] {
+		return "", fmt.Errorf("file %q has unsupported type %s", fileName, contentType)
+	}
+	return contentType, nil
+}
+
+// storeBlob stores data under its content hash unless a blob with the same
+// content exists already, and returns the hash.
+func storeBlob(tx *gorm.DB, data []byte, contentType string) (string, error) {
+	hash := sha256Hex(data)
+
+	var count int
+	if err := tx.Model(&Blob{}).Where("hash = ?", hash).Count(&count).Error; err != nil {
+		return "", err
+	}
+	if count > 0 {
+		return hash, nil
+	}
+
+	// The file may be in the store without a record after a failed upload
+	exists, err := blobs.Exists(hash)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := blobs.Put(hash, data); err != nil {
+			return "", err
+		}
+	}
+
+	// A concurrent upload of the same content may have recorded the blob since
+	// the count; its record is as good as ours
+	blob := Blob{Hash: hash, Size: len(data), ContentType: contentType, CreatedAt: clock.Now()}
+	err = tx.Set("gorm:insert_option", "ON CONFLICT (hash) DO NOTHING").Create(&blob).Error
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// readUpload reads the multipart file field of the request, at most limit
+// bytes of it, and sniffs its type. It writes the error response itself.
+func readUpload(c *gin.Context, limit int) (string, []byte, string, bool) {
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A file is required"})
+		return "", nil, "", false
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return "", nil, "", false
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, int64(limit)+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return "", nil, "", false
+	}
+
+	contentType, err := sniffUpload(header.Filename, data, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return "", nil, "", false
+	}
+	return header.Filename, data, contentType, true
+}
+
+// saveAttachment stores the uploaded file and records it as an attachment.
+func saveAttachment(c *gin.Context, attachment *Attachment) {
+	fileName, data, contentType, ok := readUpload(c, maxAttachmentSize)
+	if !ok {
+		return
+	}
+
+	tx := db.Begin()
+	hash, err := storeBlob(tx, data, contentType)
+	if err == nil {
+		attachment.FileName = fileName
+		attachment.ContentType = contentType
+		attachment.Size = len(data)
+		attachment.Hash = hash
+		attachment.CreatedAt = clock.Now()
+		err = tx.Create(attachment).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store file"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// canSeeBid reports whether viewer may see bid, and so its attachments.
+func canSeeBid(tx *gorm.DB, product *Product, bid *Bid, viewer *Token) (bool, error) {
+	if viewer == nil || !canSeeProduct(tx, product, viewer) {
+		return false, nil
+	}
+	visible, err := visibleBids(tx, product, viewer, []Bid{*bid})
+	return len(visible) > 0, err
+}
+
+// @Summary Attach a file to a product
+// @Description Upload a drawing, spec sheet or photo for a product as multipart field "file". The type is sniffed from the content and files are limited to 20 MB. Only the requester can attach files.
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param file formData file true "File"
+// @Security ApiKeyAuth
+// @Success 201 {object} Attachment
+// @Router /products/{id}/attachments [post]
+func attachProductFile(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if product.Status == Awarded || product.Status == Cancelled || product.Status == Expired {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot attach files to a %s product", product.Status)})
+		return
+	}
+
+	saveAttachment(c, &Attachment{ProductID: product.ID, UploaderID: userID})
+}
+
+// @Summary Get the files of a product
+// @Description Get the files attached to a product. They are visible to whoever can see the product.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} Attachment
+// @Router /products/{id}/attachments [get]
+func getProductFiles(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	var attachments []Attachment
+	db.Where("product_id = ? AND bid_id IS NULL", product.ID).Order("id").Find(&attachments)
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// @Summary Attach a file to an offer
+// @Description Upload a file for a pending offer as multipart field "file". The type is sniffed from the content and files are limited to 20 MB. Only the seller can attach files.
+// @Accept multipart/form-data
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Param file formData file true "File"
+// @Security ApiKeyAuth
+// @Success 201 {object} Attachment
+// @Router /offers/{id}/attachments [post]
+func attachOfferFile(c *gin.Context) {
+	offer, product, ok := sellerOffer(c)
+	if !ok {
+		return
+	}
+
+	if err := checkChangeable(product, offer, clock.Now()); err != nil {
+		respondBidError(c, err)
+		return
+	}
+
+	bidID := offer.ID
+	saveAttachment(c, &Attachment{ProductID: product.ID, BidID: &bidID, UploaderID: offer.SellerID})
+}
+
+// @Summary Get the files of an offer
+// @Description Get the files attached to an offer. They are visible to whoever can see the offer.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Attachment
+// @Router /offers/{id}/attachments [get]
+func getOfferFiles(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var offer Bid
+	if err := db.Where("id = ?", c.Param("id")).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	visible, err := canSeeBid(db, &product, &offer, viewer)
+	if err != nil || !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	var attachments []Attachment
+	db.Where("bid_id = ?", offer.ID).Order("id").Find(&attachments)
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// @Summary Download a file
+// @Description Download a file attached to a product or offer, if the product or offer is visible to the caller.
+// @Produce octet-stream
+// @Param id path int true "Attachment ID"
+// @Success 200 {file} file
+// @Router /attachments/{id} [get]
+func downloadAttachment(c *gin.Context) {
+	var attachment Attachment
+	if err := db.Where("id = ?", c.Param("id")).First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", attachment.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	viewer, _ := viewerFromContext(c)
+	visible := canSeeProduct(db, &product, viewer)
+	if visible && attachment.BidID != nil {
+		var offer Bid
+		if err := db.Where("id = ?", *attachment.BidID).First(&offer).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+			return
+		}
+		visible, _ = canSeeBid(db, &product, &offer, viewer)
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	data, err := blobs.Get(attachment.Hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.FileName))
+	c.Data(http.StatusOK, attachment.ContentType, data)
+}
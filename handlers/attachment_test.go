@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestStoreBlobConcurrentUploads(t *testing.T) {
+	setupTestDB(t)
+
+	previous := blobs
+	blobs = &localBlobStore{dir: t.TempDir()}
+	t.Cleanup(func() { blobs = previous })
+
+	// Uploads of the same content race between the hash lookup and the insert
+	data := []byte("the same specification sheet")
+	errs := make([]error, 8)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = storeBlob(db, data, "text/plain; charset=utf-8")
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("upload %d: %v", i, err)
+		}
+	}
+	var count int
+	db.Model(&Blob{}).Where("hash = ?", sha256Hex(data)).Count(&count)
+	if count != 1 {
+		t.Errorf("blobs = %d, want 1", count)
+	}
+}
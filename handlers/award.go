@@ -0,0 +1,431 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AwardPolicy decides what happens when an auction reaches its deadline.
+type AwardPolicy string
+
+const (
+	// AwardManual leaves the closed auction for the buyer to award.
+	AwardManual AwardPolicy = "manual"
+	// AwardLowestPrice awards the best ranked bid automatically: the lowest
+	// price, or the best score when the product has a scoring model.
+	AwardLowestPrice AwardPolicy = "lowest_price"
+)
+
+// AwardPricing decides the unit price a winning bid is paid.
+type AwardPricing string
+
+const (
+	// PayOwnBid pays winners the price they bid.
+	PayOwnBid AwardPricing = "own_bid"
+	// PaySecondPrice pays winners the lowest price among the valid bids of
+	// the other sellers, but never less than their own bid. Without a
+	// runner-up the budget is paid when there is one.
+	PaySecondPrice AwardPricing = "second_price"
+)
+
+type BidOutcome int
+
+const (
+	BidPending BidOutcome = iota
+	BidWon
+	BidLost
+	BidRejected
+	BidWithdrawn
+)
+
+var bidOutcomeNames = map[BidOutcome]string{
+	BidPending:   "pending",
+	BidWon:       "won",
+	BidLost:      "lost",
+	BidRejected:  "rejected",
+	BidWithdrawn: "withdrawn",
+}
+
+func (o BidOutcome) String() string {
+	if name, ok := bidOutcomeNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("outcome(%d)", int(o))
+}
+
+func (o BidOutcome) MarshalText() ([]byte, error) {
+	return []byte(o.String()), nil
+}
+
+func (o *BidOutcome) UnmarshalText(text []byte) error {
+	for outcome, name := range bidOutcomeNames {
+		if name == string(text) {
+			*o = outcome
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown bid outcome %q", text)
+}
+
+// Award records that a quantity of a bid was awarded. A product awarded to
+// a single bid has one Award; a split award has one per winning bid. For a
+// bundle bid UnitPrice is the price of the whole bundle. UnitPrice is what
+// the seller bid and ContractPrice what the seller is paid; they differ under
+// second-price awards.
+type Award struct {
+	ID            uint      `json:"id" gorm:"primary_key"`
+	ProductID     uint      `json:"product_id" gorm:"index"`
+	LotID         *uint     `json:"lot_id,omitempty"`
+	BidID         uint      `json:"bid_id"`
+	SellerID      uint      `json:"seller_id"`
+	Quantity      float64   `json:"quantity"`
+	UnitPrice     float64   `json:"unit_price"`
+	ContractPrice float64   `json:"contract_price"`
+	ActorID       uint      `json:"actor_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// awardLine is the quantity of one bid that is being awarded. Negotiated
+// lines are paid the price both sides agreed, whatever the award pricing.
+type awardLine struct {
+	Bid        *Bid
+	Quantity   float64
+	Negotiated bool
+}
+
+// awardBid awards the product to the given bid, for all of the quantity it
+// offers. See awardBids and awardBundle.
+func awardBid(tx *gorm.DB, product *Product, bid *Bid, actorID uint, now time.Time) error {
+	if bid.isBundle() {
+		return awardBundle(tx, product, bid, actorID, now)
+	}
+	return awardBids(tx, product, []awardLine{{Bid: bid, Quantity: bid.QuantityOffered}}, actorID, now)
+}
+
+// checkAwardable refuses bids that cannot win the product.
+func checkAwardable(product *Product, bid *Bid, now time.Time) error {
+	if bid.ProductID != product.ID {
+		return fmt.Errorf("bid %d does not belong to product %d", bid.ID, product.ID)
+	}
+	if bid.IsDiscarded {
+		return fmt.Errorf("bid %d has been discarded", bid.ID)
+	}
+	if bid.Disqualified {
+		return fmt.Errorf("bid %d has been disqualified", bid.ID)
+	}
+	if product.AuctionType == CommitRevealAuction && bid.RevealedAt == nil {
+		return fmt.Errorf("bid %d has not been revealed", bid.ID)
+	}
+	if product.BafoRound && !bid.IsBafo {
+		return fmt.Errorf("bid %d is not a final offer", bid.ID)
+	}
+	if bid.expiredAt(now) {
+		return fmt.Errorf("bid %d expired at %s", bid.ID, bid.ValidUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// markWon marks a pending bid as won at the given contract price. It fails
+// when the bid was decided in the meantime.
+func markWon(tx *gorm.DB, bid *Bid, quantity, contractPrice float64) error {
+	result := tx.Model(&Bid{}).
+		Where("id = ? AND outcome = ?", bid.ID, BidPending).
+		Updates(map[string]interface{}{
+			"outcome":          BidWon,
+			"is_accepted":      true,
+			"awarded_quantity": quantity,
+			"contract_price":   contractPrice,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("bid %d is no longer pending", bid.ID)
+	}
+
+	bid.Outcome = BidWon
+	bid.IsAccepted = true
+	bid.AwardedQuantity = quantity
+	bid.ContractPrice = contractPrice
+	return nil
+}
+
+// contractPrices returns the unit price each line is paid under the award
+// pricing of the product. Under second-price awards the runner-up is the
+// lowest valid bid of a seller who is not among the winners, so discarded,
+// disqualified and unrevealed bids never set the price.
+func contractPrices(tx *gorm.DB, product *Product, lines []awardLine, lot *Lot, now time.Time) ([]float64, error) {
+	prices := make([]float64, len(lines))
+	for i, line := range lines {
+		prices[i] = line.Bid.Price
+	}
+	if product.AwardPricing != PaySecondPrice {
+		return prices, nil
+	}
+
+	winners := map[uint]bool{}
+	for _, line := range lines {
+		winners[line.Bid.SellerID] = true
+	}
+
+	var lotID *uint
+	if lot != nil {
+		lotID = &lot.ID
+	}
+	bids, err := validBids(tx, product, lotID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var runnerUp *float64
+	for i := range bids {
+		if !winners[bids[i].SellerID] && !bids[i].isBundle() {
+			runnerUp = &bids[i].Price
+			break
+		}
+	}
+	if runnerUp == nil {
+		runnerUp = budgetFor(product, lot)
+	}
+
+	for i := range prices {
+		if lines[i].Negotiated {
+			continue
+		}
+		if runnerUp != nil && *runnerUp > prices[i] {
+			prices[i] = *runnerUp
+		}
+	}
+	return prices, nil
+}
+
+// awardBids awards the product, or one of its lots, to the given bids inside
+// tx. The bids are marked as won and every other bid on the same product or lot
+// as lost. The product moves to Awarded, or for multi-lot products once all of
+// its lots are awarded. Because the status changes are conditional, a second
+// award fails even when both requests run concurrently.
+func awardBids(tx *gorm.DB, product *Product, lines []awardLine, actorID uint, now time.Time) error {
+	if len(lines) == 0 {
+		return fmt.Errorf("nothing to award")
+	}
+
+	lotID := lines[0].Bid.LotID
+	quantity := product.Quantity
+	var lot *Lot
+	if lotID != nil {
+		lot = &Lot{}
+		if err := tx.Where("id = ? AND product_id = ?", *lotID, product.ID).First(lot).Error; err != nil {
+			return fmt.Errorf("lot %d not found", *lotID)
+		}
+		quantity = lot.Quantity
+	}
+	if len(lines) > 1 && quantity == 0 {
+		return fmt.Errorf("there is no quantity to split")
+	}
+
+	total := 0.0
+	seen := map[uint]bool{}
+	for _, line := range lines {
+		bid := line.Bid
+		if seen[bid.ID] {
+			return fmt.Errorf("bid %d is awarded twice", bid.ID)
+		}
+		seen[bid.ID] = true
+
+		if err := checkAwardable(product, bid, now); err != nil {
+			return err
+		}
+		if bid.isBundle() {
+			return fmt.Errorf("bundle bid %d cannot be split", bid.ID)
+		}
+		if !sameLot(bid.LotID, lotID) {
+			return fmt.Errorf("bids of different lots cannot be awarded together")
+		}
+		if quantity > 0 && (line.Quantity <= 0 || line.Quantity > bid.QuantityOffered) {
+			return fmt.Errorf("bid %d offers a quantity of %g", bid.ID, bid.QuantityOffered)
+		}
+		total += line.Quantity
+	}
+	if quantity > 0 && total > quantity {
+		return fmt.Errorf("awarded quantity %g exceeds the requested %g", total, quantity)
+	}
+
+	prices, err := contractPrices(tx, product, lines, lot, now)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range lines {
+		bid := line.Bid
+		if err := markWon(tx, bid, line.Quantity, prices[i]); err != nil {
+			return err
+		}
+
+		award := Award{
+			ProductID:     product.ID,
+			LotID:         lotID,
+			BidID:         bid.ID,
+			SellerID:      bid.SellerID,
+			Quantity:      line.Quantity,
+			UnitPrice:     bid.Price,
+			ContractPrice: prices[i],
+			ActorID:       actorID,
+			CreatedAt:     now,
+		}
+		if err := tx.Create(&award).Error; err != nil {
+			return err
+		}
+	}
+
+	if lotID != nil {
+		err = awardLot(tx, product, *lotID, actorID)
+	} else {
+		err = transitionProduct(tx, product, Awarded, actorID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return lotScope(tx.Model(&Bid{}), lotID).
+		Where("product_id = ? AND outcome = ?", product.ID, BidPending).
+		Updates(map[string]interface{}{"outcome": BidLost, "is_accepted": false}).Error
+}
+
+// lotScope restricts a bid query to one lot, or to bids on the whole product
+// when lotID is nil.
+func lotScope(query *gorm.DB, lotID *uint) *gorm.DB {
+	if lotID == nil {
+		return query.Where("lot_id IS NULL")
+	}
+	return query.Where("lot_id = ?", *lotID)
+}
+
+// validBids returns the bids that can still win the product or the given lot,
+// lowest price first. Ties on price go to the bid that was placed first. Once
+// a best-and-final-offer round started only final offers are valid. Expired
+// bids are never valid.
+func validBids(tx *gorm.DB, product *Product, lotID *uint, now time.Time) ([]Bid, error) {
+	query := lotScope(tx, lotID).Where("product_id = ? AND is_discarded = ? AND disqualified = ? AND outcome = ?",
+		product.ID, false, false, BidPending)
+	if product.AuctionType == CommitRevealAuction {
+		query = query.Where("revealed_at IS NOT NULL")
+	}
+	if product.BafoRound {
+		query = query.Where("is_bafo = ?", true)
+	}
+
+	var bids []Bid
+	if err := query.Preload("Attributes").Order("price, id").Find(&bids).Error; err != nil {
+		return nil, err
+	}
+	return unexpiredBids(bids, now), nil
+}
+
+// rankedBids returns the bids that can still win the product or the given lot,
+// best first. Products with a scoring model rank by score, all others by price.
+func rankedBids(tx *gorm.DB, product *Product, lotID *uint, now time.Time) ([]Bid, error) {
+	bids, err := validBids(tx, product, lotID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := productAttributes(tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(attrs) > 0 {
+		scoreBids(product, attrs, bids)
+		sortByScore(bids)
+	}
+	return bids, nil
+}
+
+// applyAwardPolicy settles a closed product: it expires when no valid bid is
+// left and is awarded automatically when its policy asks for it. The lots of a
+// multi-lot product are settled one by one.
+func applyAwardPolicy(tx *gorm.DB, product *Product, now time.Time) error {
+	lots, err := productLots(tx, product.ID)
+	if err != nil {
+		return err
+	}
+	if len(lots) > 0 {
+		return applyLotAwardPolicy(tx, product, lots, now)
+	}
+
+	bids, err := rankedBids(tx, product, nil, now)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(bids) == 0:
+		return transitionProduct(tx, product, Expired, 0)
+	case product.AwardPolicy == AwardLowestPrice:
+		return awardBid(tx, product, &bids[0], 0, now)
+	}
+	return nil
+}
+
+// applyLotAwardPolicy settles the lots of a closed multi-lot product. The
+// product expires only when none of its lots has a valid bid or an award.
+func applyLotAwardPolicy(tx *gorm.DB, product *Product, lots []Lot, now time.Time) error {
+	lotIDs, candidates, err := winnerCandidates(tx, product, now)
+	if err != nil {
+		return err
+	}
+
+	// With bundles in play lots cannot be awarded one by one; the winners
+	// are determined over all lots together
+	hasBundles := false
+	for _, candidate := range candidates {
+		if len(candidate.Lots) > 1 {
+			hasBundles = true
+		}
+	}
+	if hasBundles && product.AwardPolicy == AwardLowestPrice {
+		result := solveWinners(lotIDs, candidates, time.Now().Add(wdpTimeLimit))
+		for _, bidID := range result.BidIDs {
+			var bid Bid
+			if err := tx.Where("id = ?", bidID).First(&bid).Error; err != nil {
+				return err
+			}
+			if err := awardBid(tx, product, &bid, 0, now); err != nil {
+				return err
+			}
+		}
+		if len(result.BidIDs) > 0 {
+			return nil
+		}
+	}
+
+	settled := false
+	for _, lot := range lots {
+		if lot.Awarded {
+			settled = true
+			continue
+		}
+
+		lotID := lot.ID
+		bids, err := rankedBids(tx, product, &lotID, now)
+		if err != nil {
+			return err
+		}
+		if len(bids) == 0 {
+			continue
+		}
+
+		settled = true
+		if product.AwardPolicy == AwardLowestPrice && !hasBundles {
+			if err := awardBid(tx, product, &bids[0], 0, now); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !settled {
+		return transitionProduct(tx, product, Expired, 0)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestRejectOfferRefusesDecidedOffer(t *testing.T) {
+	now := setupTestDB(t).Now()
+
+	product := Product{Title: "Cable", UserID: 1, Status: Open}
+	mustCreate(t, &product)
+	won := Bid{ProductID: product.ID, SellerID: 2, Price: 10}
+	mustCreate(t, &won)
+
+	if err := awardBid(db, &product, &won, 1, now); err != nil {
+		t.Fatal(err)
+	}
+
+	w := call(rejectOffer, http.MethodPut, idParam(won.ID), nil, &Token{UserID: 1})
+	expectStatus(t, w, http.StatusConflict)
+
+	var stored Bid
+	db.Where("id = ?", won.ID).First(&stored)
+	if stored.Outcome != BidWon {
+		t.Errorf("outcome = %s, want won", stored.Outcome)
+	}
+}
+
+func TestConcurrentAwardsPickOneWinner(t *testing.T) {
+	now := setupTestDB(t).Now()
+
+	product := Product{Title: "Cable", UserID: 1, Status: Open}
+	mustCreate(t, &product)
+	bids := []Bid{
+		{ProductID: product.ID, SellerID: 2, Price: 10},
+		{ProductID: product.ID, SellerID: 3, Price: 12},
+	}
+	for i := range bids {
+		mustCreate(t, &bids[i])
+	}
+
+	// Each request loaded the product and its bid before the other committed
+	errs := make([]error, len(bids))
+	var wg sync.WaitGroup
+	for i := range bids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			loaded, bid := product, bids[i]
+			tx := db.Begin()
+			if errs[i] = awardBid(tx, &loaded, &bid, 1, now); errs[i] != nil {
+				tx.Rollback()
+				return
+			}
+			errs[i] = tx.Commit().Error
+		}(i)
+	}
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			if winner >= 0 {
+				t.Fatalf("bids %d and %d were both awarded", bids[winner].ID, bids[i].ID)
+			}
+			winner = i
+		}
+	}
+	if winner < 0 {
+		t.Fatalf("no award went through: %v", errs)
+	}
+
+	for i := range bids {
+		var stored Bid
+		db.Where("id = ?", bids[i].ID).First(&stored)
+		want := BidLost
+		if i == winner {
+			want = BidWon
+		}
+		if stored.Outcome != want {
+			t.Errorf("bid %d outcome = %s, want %s", stored.ID, stored.Outcome, want)
+		}
+	}
+
+	var awards int
+	db.Model(&Award{}).Where("product_id = ?", product.ID).Count(&awards)
+	if awards != 1 {
+		t.Errorf("awards = %d, want 1", awards)
+	}
+	var stored Product
+	db.Where("id = ?", product.ID).First(&stored)
+	if stored.Status != Awarded {
+		t.Errorf("status = %s, want awarded", stored.Status)
+	}
+}
+
+func TestContractPrices(t *testing.T) {
+	budget := 20.0
+	tests := []struct {
+		name    string
+		pricing AwardPricing
+		budget  *float64
+		winner  Bid
+		others  []Bid
+		want    float64
+	}{
+		{
+			name:    "own bid",
+			pricing: PayOwnBid,
+			winner:  Bid{SellerID: 2, Price: 10},
+			others:  []Bid{{SellerID: 3, Price: 12}},
+			want:    10,
+		},
+		{
+			name:    "lowest bid of another seller",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 10},
+			others:  []Bid{{SellerID: 3, Price: 14}, {SellerID: 4, Price: 12}},
+			want:    12,
+		},
+		{
+			name:    "winner's own bids do not set the price",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 10},
+			others:  []Bid{{SellerID: 2, Price: 11}, {SellerID: 3, Price: 15}},
+			want:    15,
+		},
+		{
+			name:    "discarded, disqualified and bundle bids do not set the price",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 10},
+			others: []Bid{
+				{SellerID: 3, Price: 11, IsDiscarded: true},
+				{SellerID: 4, Price: 12, Disqualified: true},
+				{SellerID: 5, Price: 13, BundleLotList: "1,2"},
+				{SellerID: 6, Price: 16},
+			},
+			want: 16,
+		},
+		{
+			name:    "decided bids do not set the price",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 10},
+			others:  []Bid{{SellerID: 3, Price: 11, Outcome: BidLost}, {SellerID: 4, Price: 17}},
+			want:    17,
+		},
+		{
+			name:    "budget without a runner-up",
+			pricing: PaySecondPrice,
+			budget:  &budget,
+			winner:  Bid{SellerID: 2, Price: 10},
+			want:    20,
+		},
+		{
+			name:    "own bid without a runner-up or budget",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 10},
+			want:    10,
+		},
+		{
+			name:    "never less than the own bid",
+			pricing: PaySecondPrice,
+			winner:  Bid{SellerID: 2, Price: 13},
+			others:  []Bid{{SellerID: 3, Price: 11}},
+			want:    13,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := setupTestDB(t).Now()
+
+			product := Product{Title: "Cable", UserID: 1, Status: Open, AwardPricing: tt.pricing, MaxBudget: tt.budget}
+			mustCreate(t, &product)
+			winner := tt.winner
+			winner.ProductID = product.ID
+			mustCreate(t, &winner)
+			for _, other := range tt.others {
+				other.ProductID = product.ID
+				mustCreate(t, &other)
+			}
+
+			prices, err := contractPrices(db, &product, []awardLine{{Bid: &winner}}, nil, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prices[0] != tt.want {
+				t.Errorf("contract price = %g, want %g", prices[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestContractPricesPerLot(t *testing.T) {
+	now := setupTestDB(t).Now()
+
+	product := Product{Title: "Cable", UserID: 1, Status: Open, AwardPricing: PaySecondPrice}
+	mustCreate(t, &product)
+	lotBudget := 25.0
+	lots := []Lot{
+		{ProductID: product.ID, Number: 1, Title: "Copper", MaxBudget: &lotBudget},
+		{ProductID: product.ID, Number: 2, Title: "Fibre"},
+	}
+	for i := range lots {
+		mustCreate(t, &lots[i])
+	}
+
+	// The runner-up on another lot does not count, so the lot budget is paid
+	winner := Bid{ProductID: product.ID, LotID: &lots[0].ID, SellerID: 2, Price: 10}
+	mustCreate(t, &winner)
+	mustCreate(t, &Bid{ProductID: product.ID, LotID: &lots[1].ID, SellerID: 3, Price: 12})
+
+	prices, err := contractPrices(db, &product, []awardLine{{Bid: &winner}}, &lots[0], now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices[0] != 25 {
+		t.Errorf("contract price = %g, want 25", prices[0])
+	}
+}
+
+func TestContractPricesKeepNegotiatedLines(t *testing.T) {
+	now := setupTestDB(t).Now()
+
+	product := Product{Title: "Cable", UserID: 1, Status: Open, AwardPricing: PaySecondPrice}
+	mustCreate(t, &product)
+	winner := Bid{ProductID: product.ID, SellerID: 2, Price: 10}
+	mustCreate(t, &winner)
+	mustCreate(t, &Bid{ProductID: product.ID, SellerID: 3, Price: 12})
+
+	prices, err := contractPrices(db, &product, []awardLine{{Bid: &winner, Negotiated: true}}, nil, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prices[0] != 10 {
+		t.Errorf("contract price = %g, want 10", prices[0])
+	}
+}
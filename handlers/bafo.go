@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ShortlistEntry records a seller invited to the best-and-final-offer round
+// of a product, with the rank the seller held in the first round.
+type ShortlistEntry struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	ProductID uint      `json:"product_id" gorm:"unique_index:idx_shortlist_seller"`
+	SellerID  uint      `json:"seller_id" gorm:"unique_index:idx_shortlist_seller"`
+	Rank      int       `json:"rank"`
+	BidID     uint      `json:"bid_id"`
+	CreatedAt time.Time `json:"created_at"`
+	// SubmittedAt is set when the seller places their final bid.
+	SubmittedAt *time.Time `json:"submitted_at,omitempty"`
+}
+
+type shortlistRequest struct {
+	Size     int       `json:"size" binding:"required"`
+	ClosesAt time.Time `json:"closes_at" binding:"required"`
+}
+
+// checkBafoBid limits the BAFO round to shortlisted sellers and to one final
+// bid each. The shortlist entry is claimed with a conditional update, so of two
+// concurrent final bids only one gets through. Offers outside a BAFO round are
+// left alone.
+func checkBafoBid(tx *gorm.DB, product *Product, offer *Bid, now time.Time) error {
+	offer.IsBafo = product.BafoRound
+	if !product.BafoRound {
+		return nil
+	}
+
+	var count int
+	tx.Model(&ShortlistEntry{}).Where("product_id = ? AND seller_id = ?", product.ID, offer.SellerID).Count(&count)
+	if count == 0 {
+		return &bidError{Code: "not_shortlisted", Message: "Only shortlisted sellers can bid in the final round"}
+	}
+
+	result := tx.Model(&ShortlistEntry{}).
+		Where("product_id = ? AND seller_id = ? AND submitted_at IS NULL", product.ID, offer.SellerID).
+		Update("submitted_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &bidError{Code: "bafo_already_submitted", Message: "You have already submitted your best and final offer", Conflict: true}
+	}
+	return nil
+}
+
+// @Summary Shortlist sellers for a best-and-final-offer round
+// @Description Shortlist the sellers with the top ranked bids of a closed product and reopen it until closes_at for one final bid each. Only final bids are considered for the award; earlier bids stay in the history. Only the requester can shortlist.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body shortlistRequest true "Number of sellers and new deadline"
+// @Security ApiKeyAuth
+// @Success 200 {array} ShortlistEntry
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/shortlist [post]
+func shortlistSellers(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input shortlistRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if product.Status != Closed || product.BafoRound {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only a closed first round can be shortlisted"})
+		return
+	}
+	if product.announcesPrice() || product.AuctionType == CommitRevealAuction {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This auction type has no final round"})
+		return
+	}
+	if lots, _ := productLots(db, product.ID); len(lots) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Multi-lot products have no final round"})
+		return
+	}
+	if input.Size <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Size must be positive"})
+		return
+	}
+	now := clock.Now()
+	if !input.ClosesAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "closes_at must be in the future"})
+		return
+	}
+
+	tx := db.Begin()
+
+	bids, err := rankedBids(tx, &product, nil, now)
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rank offers"})
+		return
+	}
+
+	// Each seller is shortlisted once, at the rank of their best bid
+	var shortlist []ShortlistEntry
+	seen := map[uint]bool{}
+	for _, bid := range bids {
+		if len(shortlist) == input.Size {
+			break
+		}
+		if seen[bid.SellerID] {
+			continue
+		}
+		seen[bid.SellerID] = true
+
+		entry := ShortlistEntry{ProductID: product.ID, SellerID: bid.SellerID, Rank: len(shortlist) + 1, BidID: bid.ID}
+		if err := tx.Create(&entry).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to shortlist sellers"})
+			return
+		}
+		shortlist = append(shortlist, entry)
+	}
+	if len(shortlist) == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "There are no valid offers to shortlist"})
+		return
+	}
+
+	// Reopen the product for the final round. Proxy agents do not take part
+	if err := transitionProduct(tx, &product, Open, userID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	err = tx.Model(&Product{}).Where("id = ?", product.ID).
+		Updates(map[string]interface{}{"bafo_round": true, "closes_at": input.ClosesAt}).Error
+	if err == nil {
+		err = tx.Model(&ProxyAgent{}).Where("product_id = ?", product.ID).Update("active", false).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open the final round"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, shortlist)
+}
+
+// @Summary Get the shortlist of a product
+// @Description Get the sellers shortlisted for the best-and-final-offer round of a product.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} ShortlistEntry
+// @Router /products/{id}/shortlist [get]
+func getShortlist(c *gin.Context) {
+	productID := c.Param("id")
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	// Sellers only learn whether they were shortlisted themselves
+	query := db.Where("product_id = ?", product.ID)
+	if !viewer.IsAdmin && viewer.UserID != product.UserID {
+		query = query.Where("seller_id = ?", viewer.UserID)
+	}
+
+	var shortlist []ShortlistEntry
+	query.Order("rank").Find(&shortlist)
+
+	c.JSON(http.StatusOK, shortlist)
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBafoTakesOneFinalOfferPerSeller(t *testing.T) {
+	setupTestDB(t)
+
+	product := Product{Title: "Cable", UserID: 1, Status: Open, BafoRound: true}
+	mustCreate(t, &product)
+	mustCreate(t, &ShortlistEntry{ProductID: product.ID, SellerID: 2, Rank: 1})
+
+	offer := map[string]interface{}{"price": 10, "description": "Final offer"}
+	w := call(makeOffer, http.MethodPost, idParam(product.ID), offer, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusCreated)
+
+	w = call(makeOffer, http.MethodPost, idParam(product.ID), offer, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusConflict)
+
+	w = call(makeOffer, http.MethodPost, idParam(product.ID), offer, &Token{UserID: 3})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	var count int
+	db.Model(&Bid{}).Where("product_id = ? AND is_bafo = ?", product.ID, true).Count(&count)
+	if count != 1 {
+		t.Errorf("final offers = %d, want 1", count)
+	}
+}
+
+func TestRefusedFinalOfferKeepsShortlistOpen(t *testing.T) {
+	setupTestDB(t)
+
+	product := Product{Title: "Cable", UserID: 1, Quantity: 5, Status: Open, BafoRound: true}
+	mustCreate(t, &product)
+	mustCreate(t, &ShortlistEntry{ProductID: product.ID, SellerID: 2, Rank: 1})
+
+	// A final offer refused after the shortlist check must not use up the
+	// seller's one attempt
+	w := call(makeOffer, http.MethodPost, idParam(product.ID), map[string]interface{}{"price": 10, "quantity_offered": -1, "description": "Final offer"}, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusBadRequest)
+
+	w = call(makeOffer, http.MethodPost, idParam(product.ID), map[string]interface{}{"price": 10, "description": "Final offer"}, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusCreated)
+}
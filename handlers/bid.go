@@ -2,21 +2,70 @@ package handlers
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 )
 
 // Assuming you have a Bid model
 type Bid struct {
-	ID          uint    `json:"id" gorm:"primary_key"`
-	ProductID   uint    `json:"product_id"`
-	SellerID    uint    `json:"seller_id"`
-	Price       float64 `json:"price"`
-	Description string  `json:"description"`
-	IsAccepted  bool    `json:"is_accepted"`
-	IsDiscarded bool    `json:"is_discarded"`
+	ID          uint       `json:"id" gorm:"primary_key"`
+	ProductID   uint       `json:"product_id"`
+	SellerID    uint       `json:"seller_id"`
+	Price       float64    `json:"price"`
+	Description string     `json:"description"`
+	IsAccepted  bool       `json:"is_accepted"`
+	IsDiscarded bool       `json:"is_discarded"`
+	Outcome     BidOutcome `json:"outcome"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// QuantityOffered is how much the seller can supply at Price per unit.
+	// AwardedQuantity is the part of it the buyer awarded and ContractPrice
+	// the unit price the seller is paid for it.
+	QuantityOffered float64 `json:"quantity_offered"`
+	AwardedQuantity float64 `json:"awarded_quantity,omitempty"`
+	ContractPrice   float64 `json:"contract_price,omitempty"`
+
+	// IsBafo marks a best and final offer.
+	IsBafo bool `json:"is_bafo,omitempty"`
+
+	// Version counts the changes to the bid; see BidVersion. WithdrawnAt is
+	// set when the seller withdrew it.
+	Version     int        `json:"version"`
+	WithdrawnAt *time.Time `json:"withdrawn_at,omitempty"`
+
+	// ValidUntil is when the seller's price stops being valid. Expired bids
+	// are not ranked or awarded.
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+
+	// LotID is the lot of a multi-lot product the offer is for. A bundle
+	// offer instead covers all of BundleLotIDs at Price in total.
+	LotID         *uint  `json:"lot_id,omitempty" gorm:"index"`
+	BundleLotIDs  []uint `json:"bundle_lot_ids,omitempty" gorm:"-"`
+	BundleLotList string `json:"-"`
+
+	// ProxyAgentID is set on bids placed automatically by a proxy agent.
+	ProxyAgentID *uint `json:"proxy_agent_id,omitempty"`
+
+	// Commit-reveal auctions store the commitment while bidding and the
+	// revealed price and nonce afterwards.
+	Commitment       string     `json:"commitment,omitempty"`
+	Nonce            string     `json:"nonce,omitempty"`
+	RevealedAt       *time.Time `json:"revealed_at,omitempty"`
+	Disqualified     bool       `json:"disqualified"`
+	DisqualifyReason string     `json:"disqualify_reason,omitempty"`
+
+	// Attributes carries the values for the product's scoring model. Score is
+	// computed from them on request and never stored.
+	Attributes []BidAttributeValue `json:"attributes,omitempty" gorm:"foreignkey:BidID"`
+	Score      float64             `json:"score,omitempty" gorm:"-"`
+
+	// Rank is the position of the bid among competing bids. It is only
+	// reported in rank-only auctions.
+	Rank int `json:"rank,omitempty" gorm:"-"`
 }
 
 // @Summary Make an offer on a product
@@ -28,6 +77,7 @@ type Bid struct {
 // @Security ApiKeyAuth
 // @Success 201 {object} Bid
 // @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Router /products/{id}/offers [post]
 func makeOffer(c *gin.Context) {
 	productID := c.Param("id")
@@ -38,10 +88,7 @@ func makeOffer(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
-
-	// Check if the product is still open for offers
-	if product.Status != Active {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not open for offers"})
+	if hideProduct(c, &product) {
 		return
 	}
 
@@ -52,27 +99,166 @@ func makeOffer(c *gin.Context) {
 		return
 	}
 
+	// Dutch and Japanese auctions only take responses to the announced price
+	if product.announcesPrice() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This auction only takes responses to the announced price"})
+		return
+	}
+
 	var offer Bid
 	if err := c.ShouldBindJSON(&offer); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Set the product ID and seller ID for the offer
-	offer.ProductID = product.ID
 	offer.SellerID = sellerID
+	offer.ProxyAgentID = nil
+
+	// Place the offer and let the proxy agents of other sellers respond
+	now := clock.Now()
+	tx := db.Begin()
+	if err := placeBid(tx, &product, &offer, now); err != nil {
+		tx.Rollback()
+		respondBidError(c, err)
+		return
+	}
+	if err := runProxyAgents(tx, &product, now); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run proxy agents"})
+		return
+	}
+	tx.Commit()
 
-	// Create the offer
-	db.Create(&offer)
+	// Report the new offer the way the seller is allowed to see it
+	visible, err := visibleBids(db, &product, &Token{UserID: sellerID}, []Bid{offer})
+	if err == nil && len(visible) == 1 {
+		offer = visible[0]
+	}
 
 	c.JSON(http.StatusCreated, offer)
 }
 
+// placeBid validates offer and stores it as a new bid on product. Manual and
+// proxy bids both go through here so they are held to the same rules. The
+// offer must carry its seller, price and description; everything else is set
+// here.
+func placeBid(tx *gorm.DB, product *Product, offer *Bid, now time.Time) error {
+	// Check if the product is still open for offers
+	if product.Status != Open {
+		return &bidError{Code: "not_open", Message: "Product is not open for offers"}
+	}
+
+	// Check if the bidding window is open
+	if !product.acceptsOffersAt(now) {
+		return &bidError{Code: "outside_window", Message: "Product is outside its bidding window"}
+	}
+
+	if err := checkCommitment(product, offer); err != nil {
+		return &bidError{Code: "invalid_commitment", Message: err.Error()}
+	}
+	if err := checkInvited(tx, product, offer.SellerID); err != nil {
+		return err
+	}
+	if err := checkBafoBid(tx, product, offer, now); err != nil {
+		return err
+	}
+	if err := checkValidity(offer, now); err != nil {
+		return err
+	}
+
+	// Bundles cover several whole lots at one price and are checked apart
+	var lot *Lot
+	var err error
+	if len(offer.BundleLotIDs) > 0 {
+		err = checkBundle(tx, product, offer)
+	} else {
+		offer.BundleLotList = ""
+		lot, err = bidLot(tx, product, offer)
+		if err == nil && product.AuctionType != CommitRevealAuction {
+			err = checkOfferPrice(tx, product, lot, offer.Price, now)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := checkOfferTerms(tx, product, lot, offer); err != nil {
+		return err
+	}
+
+	// Set the product ID for the offer
+	offer.ID = 0
+	offer.ProductID = product.ID
+	offer.IsAccepted = false
+	offer.IsDiscarded = false
+	offer.Outcome = BidPending
+	offer.AwardedQuantity = 0
+	offer.ContractPrice = 0
+	offer.WithdrawnAt = nil
+	offer.Version = 1
+	offer.CreatedAt = now
+
+	// Create the offer, keep its first version and extend the deadline if it
+	// arrived late
+	if err := tx.Create(offer).Error; err != nil {
+		return err
+	}
+	if err := recordBidVersion(tx, offer, ChangePlaced, now); err != nil {
+		return err
+	}
+	return extendForLateBid(tx, product, now)
+}
+
+// checkOfferTerms checks the quantity and attribute values of an offer on
+// product, or on lot when it is set, and fills in the default quantity.
+func checkOfferTerms(tx *gorm.DB, product *Product, lot *Lot, offer *Bid) error {
+	// Offers on a product or lot with a quantity cover all of it unless they
+	// say less
+	quantity := product.Quantity
+	if lot != nil {
+		quantity = lot.Quantity
+	}
+	if offer.isBundle() {
+		quantity = 0
+	}
+	switch {
+	case quantity == 0:
+		offer.QuantityOffered = 0
+	case offer.QuantityOffered == 0:
+		offer.QuantityOffered = quantity
+	case offer.QuantityOffered < 0 || offer.QuantityOffered > quantity:
+		return &bidError{Code: "invalid_quantity", Message: "Quantity offered must be between zero and the requested quantity"}
+	}
+
+	attrs, err := productAttributes(tx, product.ID)
+	if err != nil {
+		return err
+	}
+	if err := checkBidAttributes(attrs, offer); err != nil {
+		return &bidError{Code: "invalid_attributes", Message: err.Error()}
+	}
+	return nil
+}
+
+// respondBidError writes a refused bid as a structured 400 response, or 409 if
+// it lost a race, and any other error as a 500.
+func respondBidError(c *gin.Context, err error) {
+	if bidErr, ok := err.(*bidError); ok {
+		if bidErr.Conflict {
+			c.JSON(http.StatusConflict, bidErr.response())
+			return
+		}
+		c.JSON(http.StatusBadRequest, bidErr.response())
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to place offer"})
+}
+
 // @Summary Get offers for a product
 // @Description Get a list of offers for a specific product.
 // @Accept json
 // @Produce json
 // @Param id path int true "Product ID"
+// @Param sort query string false "Set to score to list the best scored offers first"
+// @Param lot_id query int false "Only list the offers on this lot"
 // @Security ApiKeyAuth
 // @Success 200 {array} Bid
 // @Router /products/{id}/offers [get]
@@ -85,13 +271,87 @@ func getOffers(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
 		return
 	}
+	if hideProduct(c, &product) {
+		return
+	}
 
-	var offers []Bid
-	db.Where("product_id = ?", product.ID).Find(&offers)
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Offers are listed lot by lot, or for a single lot when one is asked for
+	var lotIDs []*uint
+	if lotParam := c.Query("lot_id"); lotParam != "" {
+		lotID, err := strconv.Atoi(lotParam)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lot_id"})
+			return
+		}
+		id := uint(lotID)
+		lotIDs = append(lotIDs, &id)
+	} else {
+		lots, err := productLots(db, product.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lots"})
+			return
+		}
+		for i := range lots {
+			lotIDs = append(lotIDs, &lots[i].ID)
+		}
+
+		// Offers without a lot are either on the whole product or bundles
+		lotIDs = append(lotIDs, nil)
+	}
+
+	offers := []Bid{}
+	for _, lotID := range lotIDs {
+		lotOffers, err := lotOffers(db, &product, lotID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+			return
+		}
+		if c.Query("sort") == "score" {
+			sortByScore(lotOffers)
+		}
+		offers = append(offers, lotOffers...)
+	}
+
+	// Hide the bids the user is not allowed to see
+	offers, err = visibleBids(db, &product, viewer, offers)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+		return
+	}
 
 	c.JSON(http.StatusOK, offers)
 }
 
+// lotOffers returns every offer on the product or one of its lots. Offers that
+// can still win are scored against each other.
+func lotOffers(tx *gorm.DB, product *Product, lotID *uint) ([]Bid, error) {
+	var offers []Bid
+	err := lotScope(tx, lotID).Where("product_id = ?", product.ID).
+		Preload("Attributes").Order("id").Find(&offers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ranked, err := rankedBids(tx, product, lotID, clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	scores := map[uint]float64{}
+	for _, bid := range ranked {
+		scores[bid.ID] = bid.Score
+	}
+	for i := range offers {
+		offers[i].Score = scores[offers[i].ID]
+	}
+	return offers, nil
+}
+
 // @Summary Reject an offer
 // @Description Accept an offer for a product by the requester.
 // @Accept json
@@ -134,10 +394,31 @@ func rejectOffer(c *gin.Context) {
 		return
 	}
 
-	// Mark the offer as accepted (perform your logic here)
-	// For example, update the status of the offer in the database
-	offer.IsAccepted = false
-	db.Save(&offer)
+	// Offers can only be decided while the request is open or closed
+	if product.Status != Open && product.Status != Closed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offers cannot be decided on a %s product", product.Status)})
+		return
+	}
+
+	// An awarded offer cannot be rejected afterwards
+	if offer.Outcome != BidPending {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offer is already %s", offer.Outcome)})
+		return
+	}
+
+	// Conditional on the offer still being pending, so a concurrent award or
+	// revision is never overwritten
+	result := db.Model(&Bid{}).
+		Where("id = ? AND outcome = ?", offer.ID, BidPending).
+		Updates(map[string]interface{}{"outcome": BidRejected, "is_accepted": false})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject offer"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offer was decided in the meantime"})
+		return
+	}
 
 	c.Status(http.StatusNoContent)
 }
@@ -179,17 +460,44 @@ func acceptOffer(c *gin.Context) {
 		return
 	}
 
-	log.Println(">>>>: ", product.UserID)
-
 	if product.UserID != userID {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
 		return
 	}
 
-	// Mark the offer as accepted (perform your logic here)
-	// For example, update the status of the offer in the database
-	offer.IsAccepted = true
-	db.Save(&offer)
+	// Offers can only be decided while the request is open or closed
+	if product.Status != Open && product.Status != Closed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offers cannot be decided on a %s product", product.Status)})
+		return
+	}
+
+	// Sealed offers can only be awarded once every seller had the chance to reveal
+	now := clock.Now()
+	if product.inRevealPhase(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offers cannot be awarded during the reveal phase"})
+		return
+	}
+
+	if offer.expiredAt(now) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":       fmt.Sprintf("Offer expired at %s; ask the seller to extend it", offer.ValidUntil.Format(time.RFC3339)),
+			"code":        "offer_expired",
+			"valid_until": offer.ValidUntil,
+		})
+		return
+	}
+
+	// Award the product to this offer and reject every competing offer
+	tx := db.Begin()
+	if err := awardBid(tx, &product, &offer, userID, now); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to award offer"})
+		return
+	}
 
 	c.Status(http.StatusNoContent)
 }
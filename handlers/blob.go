@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BlobStore keeps file contents by key. Attachments are stored under the
+// SHA-256 of their content, so the same file is only ever stored once.
+type BlobStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Exists(key string) (bool, error)
+}
+
+// blobs is the store attachments are written to. Run sets it up from the
+// environment; see newBlobStore.
+var blobs BlobStore = &localBlobStore{dir: "uploads"}
+
+// newBlobStore picks the blob store from the environment. BLOB_STORE=s3 uses
+// an S3-compatible service such as MinIO configured by S3_ENDPOINT,
+// S3_BUCKET, S3_REGION, S3_ACCESS_KEY and S3_SECRET_KEY. Anything else
+// stores files under BLOB_DIR, "uploads" by default.
+func newBlobStore() BlobStore {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		region := os.Getenv("S3_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		return &s3BlobStore{
+			endpoint:  strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+			bucket:    os.Getenv("S3_BUCKET"),
+			region:    region,
+			accessKey: os.Getenv("S3_ACCESS_KEY"),
+			secretKey: os.Getenv("S3_SECRET_KEY"),
+			client:    &http.Client{Timeout: time.Minute},
+		}
+	}
+
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = "uploads"
+	}
+	return &localBlobStore{dir: dir}
+}
+
+// localBlobStore keeps blobs as files under dir, fanned out by the first two
+// characters of the key.
+type localBlobStore struct {
+	dir string
+}
+
+func (s *localBlobStore) path(key string) string {
+	if len(key) > 2 {
+		return filepath.Join(s.dir, key[:2], key[2:])
+	}
+	return filepath.Join(s.dir, key)
+}
+
+// Put writes through a temporary file so a crash never leaves a partial
+// blob under its final name.
+func (s *localBlobStore) Put(key string, data []byte) error {
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localBlobStore) Get(key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+func (s *localBlobStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// s3BlobStore keeps blobs in a bucket of an S3-compatible service. Requests
+// use path-style URLs and AWS Signature Version 4, which MinIO accepts as
+// well as S3 itself.
+type s3BlobStore struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func (s *s3BlobStore) Put(key string, data []byte) error {
+	resp, err := s.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3: put %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *s3BlobStore) Get(key string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("s3: get %s: %s", key, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *s3BlobStore) Exists(key string) (bool, error) {
+	resp, err := s.do(http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("s3: head %s: %s", key, resp.Status)
+}
+
+// do sends a signed request for the object key.
+func (s *s3BlobStore) do(method, key string, body []byte) (*http.Response, error) {
+	target, err := url.Parse(s.endpoint + "/" + s.bucket + "/" + key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	return s.client.Do(req)
+}
+
+// sign adds the headers of AWS Signature Version 4 to req.
+func (s *s3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,362 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// isBundle reports whether the bid covers several lots at one price.
+func (b *Bid) isBundle() bool {
+	return b.BundleLotList != ""
+}
+
+func (b *Bid) AfterFind() error {
+	b.BundleLotIDs = parseBundleLots(b.BundleLotList)
+	return nil
+}
+
+// bundleLotList stores lot IDs as ",1,3," so that a single lot can be matched
+// with LIKE.
+func bundleLotList(lotIDs []uint) string {
+	parts := make([]string, len(lotIDs))
+	for i, id := range lotIDs {
+		parts[i] = strconv.FormatUint(uint64(id), 10)
+	}
+	return "," + strings.Join(parts, ",") + ","
+}
+
+func parseBundleLots(list string) []uint {
+	var lotIDs []uint
+	for _, part := range strings.Split(strings.Trim(list, ","), ",") {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			lotIDs = append(lotIDs, uint(id))
+		}
+	}
+	return lotIDs
+}
+
+func bundleLotPattern(lotID uint) string {
+	return "%," + strconv.FormatUint(uint64(lotID), 10) + ",%"
+}
+
+// checkBundle validates a bundle offer: it must cover at least two distinct
+// lots of the product that are still open, and its price must fit within the
+// combined budget of those lots.
+func checkBundle(tx *gorm.DB, product *Product, offer *Bid) error {
+	lots, err := productLots(tx, product.ID)
+	if err != nil {
+		return err
+	}
+
+	byID := map[uint]*Lot{}
+	for i := range lots {
+		byID[lots[i].ID] = &lots[i]
+	}
+
+	lotIDs := append([]uint(nil), offer.BundleLotIDs...)
+	sort.Slice(lotIDs, func(i, j int) bool { return lotIDs[i] < lotIDs[j] })
+
+	budget := 0.0
+	hasBudget := true
+	for i, id := range lotIDs {
+		if i > 0 && lotIDs[i-1] == id {
+			return &bidError{Code: "invalid_bundle", Message: "A bundle cannot name a lot twice"}
+		}
+		lot, ok := byID[id]
+		if !ok {
+			return &bidError{Code: "unknown_lot", Message: "Lot does not belong to this product"}
+		}
+		if lot.Awarded {
+			return &bidError{Code: "lot_awarded", Message: "Lot has already been awarded"}
+		}
+		if lotBudget := budgetFor(product, lot); lotBudget != nil {
+			// Lot budgets are unit prices when the lot has a quantity
+			budget += *lotBudget * unitsOf(lot.Quantity)
+		} else {
+			hasBudget = false
+		}
+	}
+	if len(lotIDs) < 2 {
+		return &bidError{Code: "invalid_bundle", Message: "A bundle must cover at least two lots"}
+	}
+
+	if product.AuctionType != CommitRevealAuction {
+		if offer.Price <= 0 {
+			return &bidError{Code: "price_not_positive", Message: "Price must be greater than zero"}
+		}
+		if hasBudget && offer.Price > budget {
+			return &bidError{Code: "price_above_budget", Message: "Price is above the buyer's budget", MaxAcceptablePrice: &budget}
+		}
+	}
+
+	offer.LotID = nil
+	offer.BundleLotIDs = lotIDs
+	offer.BundleLotList = bundleLotList(lotIDs)
+	return nil
+}
+
+// unitsOf treats a missing quantity as a single unit.
+func unitsOf(quantity float64) float64 {
+	if quantity == 0 {
+		return 1
+	}
+	return quantity
+}
+
+// awardBundle awards every lot of a bundle bid to it. Bids on those lots and
+// other bundles touching them are lost. Bundles are always paid their own
+// price, whatever the award pricing of the product.
+func awardBundle(tx *gorm.DB, product *Product, bid *Bid, actorID uint, now time.Time) error {
+	if err := checkAwardable(product, bid, now); err != nil {
+		return err
+	}
+	if err := markWon(tx, bid, 0, bid.Price); err != nil {
+		return err
+	}
+
+	award := Award{
+		ProductID:     product.ID,
+		BidID:         bid.ID,
+		SellerID:      bid.SellerID,
+		UnitPrice:     bid.Price,
+		ContractPrice: bid.Price,
+		ActorID:       actorID,
+		CreatedAt:     now,
+	}
+	if err := tx.Create(&award).Error; err != nil {
+		return err
+	}
+
+	for _, lotID := range bid.BundleLotIDs {
+		if err := awardLot(tx, product, lotID, actorID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// winnerCandidates collects the inputs of winner determination: the open lots
+// of the product and the valid bids on them. Single-lot bids only count when
+// they offer the whole quantity of their lot.
+func winnerCandidates(tx *gorm.DB, product *Product, now time.Time) ([]uint, []wdpBid, error) {
+	lots, err := productLots(tx, product.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var lotIDs []uint
+	open := map[uint]*Lot{}
+	var candidates []wdpBid
+	for i := range lots {
+		lot := &lots[i]
+		if lot.Awarded {
+			continue
+		}
+		lotIDs = append(lotIDs, lot.ID)
+		open[lot.ID] = lot
+
+		bids, err := validBids(tx, product, &lot.ID, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, bid := range bids {
+			if lot.Quantity > 0 && bid.QuantityOffered < lot.Quantity {
+				continue
+			}
+			candidates = append(candidates, wdpBid{BidID: bid.ID, Lots: []uint{lot.ID}, Cost: bid.Price * unitsOf(lot.Quantity)})
+		}
+	}
+
+	bundles, err := validBids(tx, product, nil, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, bid := range bundles {
+		if !bid.isBundle() {
+			continue
+		}
+		usable := true
+		for _, lotID := range bid.BundleLotIDs {
+			if open[lotID] == nil {
+				usable = false
+			}
+		}
+		if usable {
+			candidates = append(candidates, wdpBid{BidID: bid.ID, Lots: bid.BundleLotIDs, Cost: bid.Price})
+		}
+	}
+
+	return lotIDs, candidates, nil
+}
+
+// AwardProposal is a winner determination result the buyer can confirm.
+type AwardProposal struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	ProductID uint      `json:"product_id" gorm:"index"`
+	BidIDs    []uint    `json:"bid_ids" gorm:"-"`
+	BidList   string    `json:"-"`
+	Uncovered []uint    `json:"uncovered_lot_ids" gorm:"-"`
+	TotalCost float64   `json:"total_cost"`
+	Method    string    `json:"method"`
+	Exact     bool      `json:"exact"`
+	Confirmed bool      `json:"confirmed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (p *AwardProposal) AfterFind() error {
+	p.BidIDs = parseBundleLots(p.BidList)
+	return nil
+}
+
+// wdpTimeLimit bounds the exact search before the heuristic result is used.
+const wdpTimeLimit = 2 * time.Second
+
+// @Summary Propose winners of a multi-lot product
+// @Description Pick the cheapest combination of non-overlapping single-lot and bundle offers and store it as a proposed award. Only the requester can ask for it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 201 {object} AwardProposal
+// @Failure 400 {object} map[string]interface{}
+// @Router /products/{id}/proposals [post]
+func proposeWinners(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// The buyer only gets to see sealed prices after the close
+	if product.isSealed() && product.isBiddingPhase() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offers of a sealed auction are hidden until it closes"})
+		return
+	}
+
+	now := clock.Now()
+	lotIDs, candidates, err := winnerCandidates(db, &product, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+		return
+	}
+	if len(lotIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product has no open lots"})
+		return
+	}
+
+	result := solveWinners(lotIDs, candidates, time.Now().Add(wdpTimeLimit))
+
+	proposal := AwardProposal{
+		ProductID: product.ID,
+		BidIDs:    result.BidIDs,
+		BidList:   bundleLotList(result.BidIDs),
+		Uncovered: result.Uncovered,
+		TotalCost: result.Cost,
+		Method:    result.Method,
+		Exact:     result.Exact,
+		CreatedAt: now,
+	}
+	if proposal.BidIDs == nil {
+		proposal.BidIDs = []uint{}
+	}
+	db.Create(&proposal)
+
+	c.JSON(http.StatusCreated, proposal)
+}
+
+// @Summary Confirm a proposed award
+// @Description Award every offer of a stored proposal in one transaction. The proposal fails when any of its offers was decided in the meantime.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param proposal_id path int true "Proposal ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/proposals/{proposal_id}/confirm [post]
+func confirmProposal(c *gin.Context) {
+	productID := c.Param("id")
+	proposalID := c.Param("proposal_id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	now := clock.Now()
+	if product.inRevealPhase(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offers cannot be awarded during the reveal phase"})
+		return
+	}
+
+	var proposal AwardProposal
+	if err := db.Where("id = ? AND product_id = ?", proposalID, product.ID).First(&proposal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proposal not found"})
+		return
+	}
+
+	tx := db.Begin()
+	result := tx.Model(&AwardProposal{}).
+		Where("id = ? AND confirmed = ?", proposal.ID, false).
+		Update("confirmed", true)
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Proposal has already been confirmed"})
+		return
+	}
+
+	for _, bidID := range proposal.BidIDs {
+		var bid Bid
+		if err := tx.Where("id = ? AND product_id = ?", bidID, product.ID).First(&bid).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offer %d no longer exists", bidID)})
+			return
+		}
+		if err := awardBid(tx, &product, &bid, userID, now); err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to confirm proposal"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Category is a node of the product taxonomy, such as a UNSPSC segment,
+// family, class or commodity. Path lists the IDs from the root down to the
+// category, as in "/1/4/9/", so a subtree is every path with its prefix.
+type Category struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	ParentID  *uint     `json:"parent_id,omitempty" gorm:"index"`
+	Code      string    `json:"code" gorm:"unique_index"`
+	Name      string    `json:"name"`
+	Path      string    `json:"path" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SellerCategory records a category a seller serves. Serving a category
+// covers all of its subcategories.
+type SellerCategory struct {
+	ID         uint `json:"id" gorm:"primary_key"`
+	SellerID   uint `json:"seller_id" gorm:"unique_index:idx_seller_category"`
+	CategoryID uint `json:"category_id" gorm:"unique_index:idx_seller_category"`
+}
+
+// subtreeIDs returns a query for the IDs of a category and everything below
+// it.
+func subtreeIDs(tx *gorm.DB, category *Category) interface{} {
+	return tx.Model(&Category{}).Select("id").Where("path LIKE ?", category.Path+"%").QueryExpr()
+}
+
+// ancestorIDs returns the IDs on the path of a category, root first.
+func (c *Category) ancestorIDs() []uint {
+	var ids []uint
+	for _, part := range splitPath(c.Path) {
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+func splitPath(path string) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(path); i++ {
+		if path[i] == '/' {
+			if i > start {
+				parts = append(parts, path[start:i])
+			}
+			start = i + 1
+		}
+	}
+	return parts
+}
+
+// sellersServing returns the sellers who serve the category or one of its
+// ancestors.
+func sellersServing(tx *gorm.DB, categoryID uint) ([]uint, error) {
+	var category Category
+	if err := tx.Where("id = ?", categoryID).First(&category).Error; err != nil {
+		return nil, err
+	}
+
+	var sellerIDs []uint
+	err := tx.Model(&SellerCategory{}).
+		Where("category_id IN (?)", category.ancestorIDs()).
+		Pluck("DISTINCT seller_id", &sellerIDs).Error
+	return sellerIDs, err
+}
+
+// checkCategory makes sure a new product is filed under an existing category.
+func checkCategory(tx *gorm.DB, product *Product) error {
+	if product.CategoryID == 0 {
+		return fmt.Errorf("category_id is required")
+	}
+
+	var count int
+	tx.Model(&Category{}).Where("id = ?", product.CategoryID).Count(&count)
+	if count == 0 {
+		return fmt.Errorf("category %d does not exist", product.CategoryID)
+	}
+	return nil
+}
+
+// requireAdmin answers with an error unless the caller is an admin. It
+// reports whether the caller is one.
+func requireAdmin(c *gin.Context) bool {
+	isAdmin, err := isAdminUser(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return false
+	}
+	if !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return false
+	}
+	return true
+}
+
+type categoryRequest struct {
+	ParentID *uint  `json:"parent_id"`
+	Code     string `json:"code" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+}
+
+// @Summary List categories
+// @Description Get the category tree as a flat list ordered by path. Pass parent_id to get the direct children of one category, or roots=true for the top level.
+// @Accept json
+// @Produce json
+// @Param parent_id query int false "Parent category"
+// @Param roots query bool false "Only top-level categories"
+// @Success 200 {array} Category
+// @Router /categories [get]
+func listCategories(c *gin.Context) {
+	query := db.Order("path")
+	if parentID := c.Query("parent_id"); parentID != "" {
+		query = query.Where("parent_id = ?", parentID)
+	} else if c.Query("roots") == "true" {
+		query = query.Where("parent_id IS NULL")
+	}
+
+	var categories []Category
+	query.Find(&categories)
+
+	c.JSON(http.StatusOK, categories)
+}
+
+// @Summary Create a category
+// @Description Add a category to the tree, under parent_id or at the top level. Only admins can manage categories.
+// @Accept json
+// @Produce json
+// @Param input body categoryRequest true "Category"
+// @Security ApiKeyAuth
+// @Success 201 {object} Category
+// @Router /categories [post]
+func createCategory(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	var input categoryRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	parentPath := "/"
+	if input.ParentID != nil {
+		var parent Category
+		if err := db.Where("id = ?", *input.ParentID).First(&parent).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+			return
+		}
+		parentPath = parent.Path
+	}
+
+	// The path holds the category's own ID, which is only known once it exists
+	category := Category{ParentID: input.ParentID, Code: input.Code, Name: input.Name, CreatedAt: clock.Now()}
+	tx := db.Begin()
+	err := tx.Create(&category).Error
+	if err == nil {
+		category.Path = fmt.Sprintf("%s%d/", parentPath, category.ID)
+		err = tx.Model(&category).Update("path", category.Path).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Category code is already taken"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, category)
+}
+
+// @Summary Update a category
+// @Description Rename a category or move it, with everything below it, under another parent. Only admins can manage categories.
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param input body categoryRequest true "Category"
+// @Security ApiKeyAuth
+// @Success 200 {object} Category
+// @Router /categories/{id} [put]
+func updateCategory(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	var input categoryRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var category Category
+	if err := db.Where("id = ?", c.Param("id")).First(&category).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	parentPath := "/"
+	if input.ParentID != nil {
+		var parent Category
+		if err := db.Where("id = ?", *input.ParentID).First(&parent).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Parent category not found"})
+			return
+		}
+		if len(parent.Path) >= len(category.Path) && parent.Path[:len(category.Path)] == category.Path {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A category cannot be moved below itself"})
+			return
+		}
+		parentPath = parent.Path
+	}
+
+	oldPath := category.Path
+	newPath := fmt.Sprintf("%s%d/", parentPath, category.ID)
+
+	tx := db.Begin()
+	err := tx.Model(&category).Updates(map[string]interface{}{
+		"parent_id": input.ParentID,
+		"code":      input.Code,
+		"name":      input.Name,
+	}).Error
+	if err == nil && newPath != oldPath {
+		// Re-root the whole subtree under the new path
+		err = tx.Exec("UPDATE categories SET path = ? || substr(path, ?) WHERE path LIKE ?",
+			newPath, len(oldPath)+1, oldPath+"%").Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Category code is already taken"})
+		return
+	}
+	tx.Commit()
+
+	category.ParentID = input.ParentID
+	category.Code = input.Code
+	category.Name = input.Name
+	category.Path = newPath
+	c.JSON(http.StatusOK, category)
+}
+
+// @Summary Delete a category
+// @Description Delete a category that has no subcategories and no products. Only admins can manage categories.
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Failure 409 {object} map[string]interface{}
+// @Router /categories/{id} [delete]
+func deleteCategory(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+
+	var category Category
+	if err := db.Where("id = ?", c.Param("id")).First(&category).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		return
+	}
+
+	var children, products int
+	db.Model(&Category{}).Where("parent_id = ?", category.ID).Count(&children)
+	db.Model(&Product{}).Where("category_id = ?", category.ID).Count(&products)
+	if children > 0 || products > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category still has subcategories or products"})
+		return
+	}
+
+	tx := db.Begin()
+	tx.Where("category_id = ?", category.ID).Delete(&SellerCategory{})
+	tx.Delete(&category)
+	tx.Commit()
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get the categories I serve
+// @Description Get the categories the current seller declared to serve.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Category
+// @Router /profile/categories [get]
+func getServedCategories(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var categories []Category
+	db.Where("id IN (?)", db.Model(&SellerCategory{}).Select("category_id").Where("seller_id = ?", sellerID).QueryExpr()).
+		Order("path").Find(&categories)
+
+	c.JSON(http.StatusOK, categories)
+}
+
+type servedCategoriesRequest struct {
+	CategoryIDs []uint `json:"category_ids"`
+}
+
+// @Summary Set the categories I serve
+// @Description Replace the categories the current seller serves. Serving a category covers all of its subcategories.
+// @Accept json
+// @Produce json
+// @Param input body servedCategoriesRequest true "Categories"
+// @Security ApiKeyAuth
+// @Success 200 {array} Category
+// @Router /profile/categories [put]
+func setServedCategories(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input servedCategoriesRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var categories []Category
+	if len(input.CategoryIDs) > 0 {
+		db.Where("id IN (?)", input.CategoryIDs).Order("path").Find(&categories)
+	}
+	if len(categories) != len(uniqueIDs(input.CategoryIDs)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown category"})
+		return
+	}
+
+	tx := db.Begin()
+	err = tx.Where("seller_id = ?", sellerID).Delete(&SellerCategory{}).Error
+	for _, category := range categories {
+		if err != nil {
+			break
+		}
+		err = tx.Create(&SellerCategory{SellerID: sellerID, CategoryID: category.ID}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save categories"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, categories)
+}
+
+func uniqueIDs(ids []uint) map[uint]bool {
+	seen := map[uint]bool{}
+	for _, id := range ids {
+		seen[id] = true
+	}
+	return seen
+}
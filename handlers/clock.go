@@ -0,0 +1,17 @@
+package handlers
+
+import "time"
+
+// Clock tells the current time. Handlers and the scheduler read the time
+// through clock so that deadlines can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var clock Clock = systemClock{}
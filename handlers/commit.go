@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// CommitRevealAuction hides prices from everyone, including the operator.
+// While the auction is open sellers submit only a commitment to their price.
+// After the close they reveal price and nonce, which the server checks
+// against the commitment.
+const CommitRevealAuction AuctionType = "commit_reveal"
+
+// bidCommitment returns the commitment for a price and nonce: the hex encoded
+// SHA-256 of the price in its shortest decimal form, a colon and the nonce.
+// For example a price of 1250.5 with nonce "abc" commits to
+// sha256("1250.5:abc").
+func bidCommitment(price float64, nonce string) string {
+	sum := sha256.Sum256([]byte(strconv.FormatFloat(price, 'f', -1, 64) + ":" + nonce))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkCommitment validates the sealed part of a new offer. In commit-reveal
+// auctions the offer must carry a commitment and no price; in every other
+// auction type the commitment fields are cleared.
+func checkCommitment(product *Product, offer *Bid) error {
+	offer.Nonce = ""
+	offer.RevealedAt = nil
+	offer.Disqualified = false
+	offer.DisqualifyReason = ""
+
+	if product.AuctionType != CommitRevealAuction {
+		offer.Commitment = ""
+		return nil
+	}
+
+	if offer.Price != 0 {
+		return fmt.Errorf("price must not be sent before the reveal phase")
+	}
+	if decoded, err := hex.DecodeString(offer.Commitment); err != nil || len(decoded) != sha256.Size {
+		return fmt.Errorf("commitment must be a hex encoded SHA-256 hash")
+	}
+	return nil
+}
+
+// inRevealPhase reports whether sellers can still reveal their bids at t.
+func (p *Product) inRevealPhase(t time.Time) bool {
+	return p.AuctionType == CommitRevealAuction && p.Status == Closed &&
+		p.RevealClosesAt != nil && t.Before(*p.RevealClosesAt)
+}
+
+// @Summary Reveal a sealed offer
+// @Description Reveal the price and nonce of a commit-reveal offer after the auction has closed. An offer that does not match its commitment is disqualified.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Param input body revealRequest true "Revealed price and nonce"
+// @Security ApiKeyAuth
+// @Success 200 {object} Bid
+// @Failure 409 {object} map[string]interface{}
+// @Router /offers/{id}/reveal [post]
+func revealOffer(c *gin.Context) {
+	offerID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input revealRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var offer Bid
+	if err := db.Where("id = ?", offerID).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	if offer.SellerID != sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	now := clock.Now()
+	if !product.inRevealPhase(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Product is not in its reveal phase"})
+		return
+	}
+
+	if offer.RevealedAt != nil || offer.Disqualified {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offer has already been revealed"})
+		return
+	}
+
+	// Keep what the seller revealed even when it does not match, so the
+	// disqualification can be checked against the commitment later
+	offer.Price = input.Price
+	offer.Nonce = input.Nonce
+	offer.RevealedAt = &now
+	budget := offerBudget(&product, &offer)
+	switch {
+	case bidCommitment(input.Price, input.Nonce) != offer.Commitment:
+		offer.Disqualified = true
+		offer.DisqualifyReason = "revealed price and nonce do not match the commitment"
+	case input.Price <= 0:
+		offer.Disqualified = true
+		offer.DisqualifyReason = "revealed price is not positive"
+	case budget != nil && input.Price > *budget:
+		offer.Disqualified = true
+		offer.DisqualifyReason = "revealed price is above the buyer's budget"
+	}
+
+	result := db.Model(&Bid{}).
+		Where("id = ? AND revealed_at IS NULL AND disqualified = ?", offer.ID, false).
+		Updates(map[string]interface{}{
+			"price":             offer.Price,
+			"nonce":             offer.Nonce,
+			"revealed_at":       offer.RevealedAt,
+			"disqualified":      offer.Disqualified,
+			"disqualify_reason": offer.DisqualifyReason,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reveal offer"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offer has already been revealed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, offer)
+}
+
+type revealRequest struct {
+	Price float64 `json:"price" binding:"required"`
+	Nonce string  `json:"nonce" binding:"required"`
+}
+
+// disqualifyUnrevealed disqualifies every bid on the product that was not
+// revealed before the end of the reveal phase.
+func disqualifyUnrevealed(tx *gorm.DB, product *Product) error {
+	return tx.Model(&Bid{}).
+		Where("product_id = ? AND revealed_at IS NULL AND disqualified = ?", product.ID, false).
+		Updates(map[string]interface{}{
+			"disqualified":      true,
+			"disqualify_reason": "not revealed before the reveal deadline",
+		}).Error
+}
+
+// offerBudget returns the budget that applies to an existing offer.
+func offerBudget(product *Product, offer *Bid) *float64 {
+	if offer.LotID != nil {
+		var lot Lot
+		if db.Where("id = ?", *offer.LotID).First(&lot).Error == nil {
+			return budgetFor(product, &lot)
+		}
+	}
+	return product.MaxBudget
+}
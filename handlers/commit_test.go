@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBidCommitment(t *testing.T) {
+	// sha256("1250.5:abc"), as documented on bidCommitment
+	want := "ad8be448f8b05b225af4437072337e8c30cdc32b73b1c932d2825f7180ac9033"
+	if got := bidCommitment(1250.5, "abc"); got != want {
+		t.Errorf("bidCommitment(1250.5, abc) = %s, want %s", got, want)
+	}
+	if bidCommitment(1250.50, "abc") != bidCommitment(1250.5, "abc") {
+		t.Error("trailing zeros changed the commitment")
+	}
+}
+
+func TestCheckCommitment(t *testing.T) {
+	product := Product{AuctionType: CommitRevealAuction}
+	tests := []struct {
+		name  string
+		offer Bid
+		ok    bool
+	}{
+		{"valid", Bid{Commitment: bidCommitment(10, "n")}, true},
+		{"price sent early", Bid{Price: 10, Commitment: bidCommitment(10, "n")}, false},
+		{"not hex", Bid{Commitment: "zz"}, false},
+		{"wrong length", Bid{Commitment: "abcd"}, false},
+		{"missing", Bid{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCommitment(&product, &tt.offer)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkCommitment = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRevealOffer(t *testing.T) {
+	tests := []struct {
+		name         string
+		committed    float64
+		price        float64
+		nonce        string
+		disqualified bool
+	}{
+		{"matching", 10, 10, "secret", false},
+		{"wrong nonce", 10, 10, "guess", true},
+		{"wrong price", 10, 9, "secret", true},
+		{"above budget", 30, 30, "secret", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := setupTestDB(t).Now()
+
+			closesAt, revealClosesAt := now.Add(-time.Hour), now.Add(time.Hour)
+			budget := 20.0
+			product := Product{Title: "Cable", UserID: 1, Status: Closed, AuctionType: CommitRevealAuction,
+				ClosesAt: &closesAt, RevealClosesAt: &revealClosesAt, MaxBudget: &budget}
+			mustCreate(t, &product)
+			offer := Bid{ProductID: product.ID, SellerID: 2, Commitment: bidCommitment(tt.committed, "secret")}
+			mustCreate(t, &offer)
+
+			reveal := map[string]interface{}{"price": tt.price, "nonce": tt.nonce}
+			w := call(revealOffer, http.MethodPost, idParam(offer.ID), reveal, &Token{UserID: 2})
+			expectStatus(t, w, http.StatusOK)
+
+			stored := loadBid(t, offer.ID)
+			if stored.Disqualified != tt.disqualified {
+				t.Errorf("disqualified = %v (%s), want %v", stored.Disqualified, stored.DisqualifyReason, tt.disqualified)
+			}
+			if stored.RevealedAt == nil || stored.Price != tt.price || stored.Nonce != tt.nonce {
+				t.Errorf("reveal not recorded: %+v", stored)
+			}
+
+			// An offer is revealed once, whatever the outcome
+			w = call(revealOffer, http.MethodPost, idParam(offer.ID), reveal, &Token{UserID: 2})
+			expectStatus(t, w, http.StatusConflict)
+		})
+	}
+}
+
+func TestRevealOfferOutsideRevealPhase(t *testing.T) {
+	fake := setupTestDB(t)
+	now := fake.Now()
+
+	closesAt, revealClosesAt := now.Add(-time.Hour), now.Add(time.Hour)
+	product := Product{Title: "Cable", UserID: 1, Status: Closed, AuctionType: CommitRevealAuction,
+		ClosesAt: &closesAt, RevealClosesAt: &revealClosesAt}
+	mustCreate(t, &product)
+	offer := Bid{ProductID: product.ID, SellerID: 2, Commitment: bidCommitment(10, "secret")}
+	mustCreate(t, &offer)
+
+	reveal := map[string]interface{}{"price": 10, "nonce": "secret"}
+	w := call(revealOffer, http.MethodPost, idParam(offer.ID), reveal, &Token{UserID: 3})
+	expectStatus(t, w, http.StatusForbidden)
+
+	fake.Advance(2 * time.Hour)
+	w = call(revealOffer, http.MethodPost, idParam(offer.ID), reveal, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusConflict)
+	if loadBid(t, offer.ID).RevealedAt != nil {
+		t.Error("late reveal was recorded")
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// DutchAuction is an ascending-price reverse auction. The offered price starts
+// at StartPrice and rises by PriceStep every PriceStepMinutes up to MaxBudget.
+// The first seller to accept the current price wins immediately.
+const DutchAuction AuctionType = "dutch"
+
+// announcesPrice reports whether the system sets the price of the auction,
+// in which case sellers cannot place offers of their own.
+func (p *Product) announcesPrice() bool {
+	return p.AuctionType == DutchAuction || p.AuctionType == JapaneseAuction
+}
+
+// checkPriceSchedule validates the price schedule of a new Dutch or Japanese
+// auction and clears it for every other auction type.
+func checkPriceSchedule(product *Product) error {
+	product.CurrentPrice = 0
+	product.ClockStartedAt = nil
+
+	if !product.announcesPrice() {
+		product.StartPrice = 0
+		product.PriceStep = 0
+		product.PriceStepMinutes = 0
+		return nil
+	}
+
+	if product.AwardPricing == PaySecondPrice {
+		return fmt.Errorf("%s auctions cannot pay the second price", product.AuctionType)
+	}
+	if product.StartPrice <= 0 || product.PriceStep <= 0 || product.PriceStepMinutes <= 0 {
+		return fmt.Errorf("%s auctions need a positive start_price, price_step and price_step_minutes", product.AuctionType)
+	}
+	if len(product.Lots) > 0 || len(product.Attributes) > 0 {
+		return fmt.Errorf("%s auctions cannot have lots or scoring attributes", product.AuctionType)
+	}
+
+	if product.AuctionType == JapaneseAuction {
+		return checkJapaneseSettings(product)
+	}
+
+	if product.MaxBudget == nil || *product.MaxBudget < product.StartPrice {
+		return fmt.Errorf("dutch auctions need a max_budget of at least start_price")
+	}
+	if product.ClosesAt == nil {
+		return fmt.Errorf("dutch auctions need closes_at")
+	}
+	return nil
+}
+
+// dutchPriceAt returns the price the clock shows at t.
+func (p *Product) dutchPriceAt(t time.Time) float64 {
+	if p.ClockStartedAt == nil || t.Before(*p.ClockStartedAt) {
+		return p.StartPrice
+	}
+
+	steps := int64(t.Sub(*p.ClockStartedAt) / (time.Duration(p.PriceStepMinutes) * time.Minute))
+	price := roundCents(p.StartPrice + float64(steps)*p.PriceStep)
+	if p.MaxBudget != nil && price > *p.MaxBudget {
+		price = *p.MaxBudget
+	}
+	return price
+}
+
+// advanceDutchClock starts the clock of an open Dutch auction once its
+// bidding window opens and moves the announced price along the schedule.
+func advanceDutchClock(tx *gorm.DB, product *Product, now time.Time) error {
+	if product.Status != Open || !product.acceptsOffersAt(now) {
+		return nil
+	}
+
+	updates := map[string]interface{}{}
+	if product.ClockStartedAt == nil {
+		product.ClockStartedAt = &now
+		updates["clock_started_at"] = now
+	}
+
+	price := product.dutchPriceAt(now)
+	if price != product.CurrentPrice {
+		product.CurrentPrice = price
+		updates["current_price"] = price
+	}
+
+	if len(updates) == 0 {
+		return nil
+	}
+	return tx.Model(&Product{}).Where("id = ? AND status = ?", product.ID, Open).Updates(updates).Error
+}
+
+// advanceDutchClocks is run by the scheduler on every tick.
+func (s *scheduler) advanceDutchClocks(now time.Time) {
+	var auctions []Product
+	if err := s.db.Where("status = ? AND auction_type = ?", Open, DutchAuction).Find(&auctions).Error; err != nil {
+		log.Println("scheduler: failed to load dutch auctions:", err)
+		return
+	}
+
+	for i := range auctions {
+		if err := advanceDutchClock(s.db, &auctions[i], now); err != nil {
+			log.Printf("scheduler: failed to advance price clock of product %d: %v", auctions[i].ID, err)
+		}
+	}
+}
+
+// @Summary Accept the current price of a Dutch auction
+// @Description Accept the price a Dutch auction currently announces. The first seller to accept wins the product at that price; everyone after gets a conflict. The price follows the schedule at the moment of acceptance; sending the price seen guards against accepting a price that has moved.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body acceptPriceRequest false "Price the seller saw"
+// @Security ApiKeyAuth
+// @Success 201 {object} Bid
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/accept-price [post]
+func acceptCurrentPrice(c *gin.Context) {
+	productID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input acceptPriceRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	if product.AuctionType != DutchAuction {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not a Dutch auction"})
+		return
+	}
+	if product.UserID == sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// The stored price only moves on scheduler ticks, so the price is worked
+	// out from the schedule at the moment of acceptance
+	now := clock.Now()
+	if product.ClockStartedAt == nil && !product.acceptsOffersAt(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Price clock has not started"})
+		return
+	}
+	price := product.dutchPriceAt(now)
+	if input.Price != nil && *input.Price != price {
+		c.JSON(http.StatusConflict, gin.H{"error": "Price has changed", "current_price": price})
+		return
+	}
+
+	// The conditional status change inside awardBid lets exactly one of
+	// several concurrent acceptances through
+	offer := Bid{SellerID: sellerID, Price: price, Description: "Accepted the current price"}
+
+	tx := db.Begin()
+	if err := advanceDutchClock(tx, &product, now); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the price clock"})
+		return
+	}
+	if err := placeBid(tx, &product, &offer, now); err != nil {
+		tx.Rollback()
+		respondBidError(c, err)
+		return
+	}
+	if err := awardBid(tx, &product, &offer, 0, now); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "The auction has already been won"})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The auction has already been won"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, offer)
+}
+
+type acceptPriceRequest struct {
+	Price *float64 `json:"price"`
+}
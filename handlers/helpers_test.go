@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeClock is a Clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+// setupTestDB points db at a fresh SQLite file with every table migrated and
+// the global clock at a fixed time. Both are restored when the test ends.
+func setupTestDB(t *testing.T) *fakeClock {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "test.db")
+	testDB, err := gorm.Open("sqlite3", path+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testDB.AutoMigrate(models...).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	previousDB, previousClock := db, clock
+	db, clock = testDB, fake
+	t.Cleanup(func() {
+		db, clock = previousDB, previousClock
+		testDB.Close()
+	})
+	return fake
+}
+
+// call runs handler on a request from claims (nil for anonymous) and returns
+// the recorded response.
+func call(handler gin.HandlerFunc, method string, params gin.Params, body interface{}, claims *Token) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	c.Request = httptest.NewRequest(method, "/", bytes.NewReader(payload))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = params
+	if claims != nil {
+		c.Set("claims", claims)
+	}
+
+	handler(c)
+	c.Writer.WriteHeaderNow()
+	return w
+}
+
+func idParam(id uint) gin.Params {
+	return gin.Params{{Key: "id", Value: strconv.FormatUint(uint64(id), 10)}}
+}
+
+func mustCreate(t *testing.T, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// InvitationStatus is where an invitation to a private auction stands.
+type InvitationStatus string
+
+const (
+	InvitationPending  InvitationStatus = "pending"
+	InvitationAccepted InvitationStatus = "accepted"
+	InvitationDeclined InvitationStatus = "declined"
+	InvitationRevoked  InvitationStatus = "revoked"
+)
+
+// Invitation lets a seller into a private auction. Invitations are made out
+// to a seller, or carry a single-use Token to share as a link that binds the
+// seller who redeems it.
+type Invitation struct {
+	ID          uint             `json:"id" gorm:"primary_key"`
+	ProductID   uint             `json:"product_id" gorm:"index"`
+	SellerID    *uint            `json:"seller_id,omitempty" gorm:"index"`
+	Token       *string          `json:"token,omitempty" gorm:"unique_index"`
+	Status      InvitationStatus `json:"status"`
+	InvitedBy   uint             `json:"invited_by"`
+	RespondedAt *time.Time       `json:"responded_at,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// canSeeProduct reports whether viewer may see product. Drafts and requests
+// under review are only visible to their requester and admins. Otherwise
+// public products are visible to everyone; private ones to their requester,
+// admins and sellers with a pending or accepted invitation. A nil viewer is
+// anonymous.
+func canSeeProduct(tx *gorm.DB, product *Product, viewer *Token) bool {
+	for _, status := range unpublished {
+		if product.Status == status {
+			return viewer != nil && (viewer.IsAdmin || viewer.UserID == product.UserID)
+		}
+	}
+
+	if !product.Private {
+		return true
+	}
+	if viewer == nil {
+		return false
+	}
+	if viewer.IsAdmin || viewer.UserID == product.UserID {
+		return true
+	}
+
+	var count int
+	tx.Model(&Invitation{}).
+		Where("product_id = ? AND seller_id = ? AND status IN (?)", product.ID, viewer.UserID,
+			[]InvitationStatus{InvitationPending, InvitationAccepted}).
+		Count(&count)
+	return count > 0
+}
+
+// unpublished are the statuses of requests only their requester and admins
+// get to list.
+var unpublished = []Status{Draft, PendingReview}
+
+// visibleProducts restricts a product query to what viewer may list.
+func visibleProducts(query *gorm.DB, viewer *Token) *gorm.DB {
+	if viewer == nil {
+		return query.Where("private = ? AND status NOT IN (?)", false, unpublished)
+	}
+	if viewer.IsAdmin {
+		return query
+	}
+
+	invited := db.Model(&Invitation{}).Select("product_id").
+		Where("seller_id = ? AND status IN (?)", viewer.UserID, []InvitationStatus{InvitationPending, InvitationAccepted}).
+		QueryExpr()
+	return query.Where("user_id = ? OR ((private = ? OR id IN (?)) AND status NOT IN (?))",
+		viewer.UserID, false, invited, unpublished)
+}
+
+// hideProduct answers with a 404 when the caller may not see product, so
+// private auctions do not reveal that they exist. It reports whether it did.
+func hideProduct(c *gin.Context, product *Product) bool {
+	viewer, _ := viewerFromContext(c)
+	if canSeeProduct(db, product, viewer) {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	return true
+}
+
+// checkInvited refuses bids on a private product from sellers who have not
+// accepted an invitation.
+func checkInvited(tx *gorm.DB, product *Product, sellerID uint) error {
+	if !product.Private {
+		return nil
+	}
+
+	var count int
+	tx.Model(&Invitation{}).
+		Where("product_id = ? AND seller_id = ? AND status = ?", product.ID, sellerID, InvitationAccepted).
+		Count(&count)
+	if count == 0 {
+		return &bidError{Code: "not_invited", Message: "Only invited sellers can bid on this product"}
+	}
+	return nil
+}
+
+func invitationToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+type invitationRequest struct {
+	SellerIDs []uint `json:"seller_ids"`
+	Links     int    `json:"links"`
+}
+
+// @Summary Invite sellers to a private product
+// @Description Invite sellers by user ID and create single-use invite links. Invited sellers are notified. Only the requester can invite.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body invitationRequest true "Sellers and number of links"
+// @Security ApiKeyAuth
+// @Success 201 {array} Invitation
+// @Router /products/{id}/invitations [post]
+func inviteSellers(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input invitationRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if !product.Private {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only private products take invitations"})
+		return
+	}
+	if product.Status == Awarded || product.Status == Cancelled || product.Status == Expired {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cannot invite sellers to a %s product", product.Status)})
+		return
+	}
+	if input.Links < 0 || len(input.SellerIDs)+input.Links == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invite at least one seller or link"})
+		return
+	}
+
+	now := clock.Now()
+	invitations := []Invitation{}
+	tx := db.Begin()
+	for _, sellerID := range input.SellerIDs {
+		if sellerID == product.UserID {
+			continue
+		}
+
+		// Sellers who are already invited keep their invitation
+		var existing Invitation
+		err := tx.Where("product_id = ? AND seller_id = ? AND status IN (?)", product.ID, sellerID,
+			[]InvitationStatus{InvitationPending, InvitationAccepted}).First(&existing).Error
+		if err == nil {
+			invitations = append(invitations, existing)
+			continue
+		}
+
+		seller := sellerID
+		invitation := Invitation{ProductID: product.ID, SellerID: &seller, Status: InvitationPending, InvitedBy: userID, CreatedAt: now}
+		err = tx.Create(&invitation).Error
+		if err == nil {
+			err = notify(tx, sellerID, NotifyInvitation, product.ID, nil,
+				fmt.Sprintf("You are invited to bid on %q", product.Title))
+		}
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invite sellers"})
+			return
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	for i := 0; i < input.Links; i++ {
+		token, err := invitationToken()
+		if err == nil {
+			invitation := Invitation{ProductID: product.ID, Token: &token, Status: InvitationPending, InvitedBy: userID, CreatedAt: now}
+			err = tx.Create(&invitation).Error
+			invitations = append(invitations, invitation)
+		}
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite links"})
+			return
+		}
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, invitations)
+}
+
+// @Summary Get the invitations of a private product
+// @Description Get every invitation of a product with its status. Only the requester and admins can see them.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Invitation
+// @Router /products/{id}/invitations [get]
+func getInvitations(c *gin.Context) {
+	productID := c.Param("id")
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if !viewer.IsAdmin && viewer.UserID != product.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var invitations []Invitation
+	db.Where("product_id = ?", product.ID).Order("id").Find(&invitations)
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// @Summary Get my invitations
+// @Description Get the invitations made out to the current user, newest first.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Invitation
+// @Router /invitations [get]
+func getMyInvitations(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var invitations []Invitation
+	db.Where("seller_id = ?", userID).Order("id desc").Find(&invitations)
+
+	c.JSON(http.StatusOK, invitations)
+}
+
+// respondInvitation moves a pending invitation of the current seller to
+// status. The update is conditional so a revoked invitation cannot be
+// accepted concurrently.
+func respondInvitation(c *gin.Context, status InvitationStatus) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var invitation Invitation
+	if err := db.Where("id = ? AND seller_id = ?", c.Param("id"), userID).First(&invitation).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	now := clock.Now()
+	result := db.Model(&Invitation{}).
+		Where("id = ? AND status = ?", invitation.ID, InvitationPending).
+		Updates(map[string]interface{}{"status": status, "responded_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Invitation is already %s", invitation.Status)})
+		return
+	}
+
+	invitation.Status = status
+	invitation.RespondedAt = &now
+	c.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Accept an invitation
+// @Description Accept an invitation to a private product, which allows bidding on it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Invitation
+// @Failure 409 {object} map[string]interface{}
+// @Router /invitations/{id}/accept [post]
+func acceptInvitation(c *gin.Context) {
+	respondInvitation(c, InvitationAccepted)
+}
+
+// @Summary Decline an invitation
+// @Description Decline an invitation to a private product.
+// @Accept json
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Invitation
+// @Failure 409 {object} map[string]interface{}
+// @Router /invitations/{id}/decline [post]
+func declineInvitation(c *gin.Context) {
+	respondInvitation(c, InvitationDeclined)
+}
+
+// @Summary Accept an invite link
+// @Description Redeem a single-use invite link. The link is bound to the current user and accepted.
+// @Accept json
+// @Produce json
+// @Param token path string true "Invite token"
+// @Security ApiKeyAuth
+// @Success 200 {object} Invitation
+// @Failure 409 {object} map[string]interface{}
+// @Router /invite-links/{token}/accept [post]
+func redeemInviteLink(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var invitation Invitation
+	if err := db.Where("token = ?", c.Param("token")).First(&invitation).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", invitation.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.UserID == userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	now := clock.Now()
+	result := db.Model(&Invitation{}).
+		Where("id = ? AND status = ? AND seller_id IS NULL", invitation.ID, InvitationPending).
+		Updates(map[string]interface{}{"status": InvitationAccepted, "seller_id": userID, "responded_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invite link has already been used"})
+		return
+	}
+
+	invitation.Status = InvitationAccepted
+	invitation.SellerID = &userID
+	invitation.RespondedAt = &now
+	c.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Revoke an invitation
+// @Description Revoke an invitation or invite link. The seller can no longer see or bid on the product; bids already placed stay. Only the requester can revoke.
+// @Accept json
+// @Produce json
+// @Param id path int true "Invitation ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Invitation
+// @Failure 409 {object} map[string]interface{}
+// @Router /invitations/{id}/revoke [post]
+func revokeInvitation(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var invitation Invitation
+	if err := db.Where("id = ?", c.Param("id")).First(&invitation).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invitation not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", invitation.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	now := clock.Now()
+	tx := db.Begin()
+	result := tx.Model(&Invitation{}).
+		Where("id = ? AND status IN (?)", invitation.ID, []InvitationStatus{InvitationPending, InvitationAccepted}).
+		Updates(map[string]interface{}{"status": InvitationRevoked, "responded_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Invitation is already %s", invitation.Status)})
+		return
+	}
+
+	// The seller's proxy agent would otherwise keep trying to bid
+	if invitation.SellerID != nil {
+		err := tx.Model(&ProxyAgent{}).
+			Where("product_id = ? AND seller_id = ?", product.ID, *invitation.SellerID).
+			Update("active", false).Error
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invitation"})
+			return
+		}
+	}
+	tx.Commit()
+
+	invitation.Status = InvitationRevoked
+	invitation.RespondedAt = &now
+	c.JSON(http.StatusOK, invitation)
+}
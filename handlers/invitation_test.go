@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestInviteSellersByID(t *testing.T) {
+	setupTestDB(t)
+
+	first := Product{Title: "Steel", UserID: 1, Status: Open, Private: true}
+	second := Product{Title: "Copper", UserID: 1, Status: Open, Private: true}
+	mustCreate(t, &first)
+	mustCreate(t, &second)
+
+	buyer := &Token{UserID: 1}
+	w := call(inviteSellers, http.MethodPost, idParam(first.ID), invitationRequest{SellerIDs: []uint{2, 3}, Links: 1}, buyer)
+	expectStatus(t, w, http.StatusCreated)
+	w = call(inviteSellers, http.MethodPost, idParam(second.ID), invitationRequest{SellerIDs: []uint{2}}, buyer)
+	expectStatus(t, w, http.StatusCreated)
+
+	var invitations []Invitation
+	db.Order("id").Find(&invitations)
+	if len(invitations) != 4 {
+		t.Fatalf("got %d invitations, want 4", len(invitations))
+	}
+	for _, invitation := range invitations {
+		if (invitation.SellerID == nil) == (invitation.Token == nil) {
+			t.Errorf("invitation %d should have either a seller or a token", invitation.ID)
+		}
+	}
+
+	seller := &Token{UserID: 3}
+	if !canSeeProduct(db, &first, seller) || canSeeProduct(db, &second, seller) {
+		t.Error("seller 3 should only see the product they were invited to")
+	}
+}
+
+func TestUnpublishedProductsAreHidden(t *testing.T) {
+	setupTestDB(t)
+
+	draft := Product{Title: "Draft", UserID: 1, Status: Draft}
+	mustCreate(t, &draft)
+
+	for _, viewer := range []*Token{nil, {UserID: 2}} {
+		w := call(getOffers, http.MethodGet, idParam(draft.ID), nil, viewer)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("viewer %v: status = %d, want 404", viewer, w.Code)
+		}
+	}
+	for _, viewer := range []*Token{{UserID: 1}, {UserID: 9, IsAdmin: true}} {
+		if !canSeeProduct(db, &draft, viewer) {
+			t.Errorf("viewer %v should see the draft", viewer)
+		}
+	}
+}
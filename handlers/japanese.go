@@ -0,0 +1,365 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// JapaneseAuction is a round-based elimination auction. Every round announces
+// a price PriceStep below the last one and sellers must confirm they stay in
+// or they are eliminated. The auction ends when one seller remains or nobody
+// confirms.
+const JapaneseAuction AuctionType = "japanese"
+
+// AuctionRound is a single round of a Japanese auction. Remaining is the
+// number of sellers who stayed in, filled in when the round is closed.
+type AuctionRound struct {
+	ID        uint            `json:"id" gorm:"primary_key"`
+	ProductID uint            `json:"product_id" gorm:"index"`
+	Number    int             `json:"number"`
+	Price     float64         `json:"price"`
+	OpensAt   time.Time       `json:"opens_at"`
+	ClosesAt  time.Time       `json:"closes_at"`
+	ClosedAt  *time.Time      `json:"closed_at,omitempty"`
+	Remaining int             `json:"remaining"`
+	Responses []RoundResponse `json:"responses,omitempty" gorm:"foreignkey:RoundID"`
+}
+
+// RoundParticipant is the participation state of a seller in a Japanese
+// auction. Sellers join by staying in the first round.
+type RoundParticipant struct {
+	ID                uint      `json:"id" gorm:"primary_key"`
+	ProductID         uint      `json:"product_id" gorm:"unique_index:idx_round_participant"`
+	SellerID          uint      `json:"seller_id" gorm:"unique_index:idx_round_participant"`
+	Active            bool      `json:"active"`
+	EliminatedInRound int       `json:"eliminated_in_round,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// RoundResponse records the answer of a seller to a round.
+type RoundResponse struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	RoundID   uint      `json:"round_id" gorm:"unique_index:idx_round_response"`
+	ProductID uint      `json:"product_id" gorm:"index"`
+	SellerID  uint      `json:"seller_id" gorm:"unique_index:idx_round_response"`
+	StayIn    bool      `json:"stay_in"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// checkJapaneseSettings validates what is specific to Japanese auctions. Their
+// rounds decide when they end, so they cannot have a closing time.
+func checkJapaneseSettings(product *Product) error {
+	if product.ClosesAt != nil {
+		return fmt.Errorf("japanese auctions end by their rounds and cannot have closes_at")
+	}
+	if product.PriceStep >= product.StartPrice {
+		return fmt.Errorf("price_step must be below start_price")
+	}
+	if product.MaxBudget != nil && *product.MaxBudget < product.StartPrice {
+		return fmt.Errorf("start_price must not be above max_budget")
+	}
+	return nil
+}
+
+// currentRound returns the latest round of a product, or nil before the first.
+func currentRound(tx *gorm.DB, productID uint) (*AuctionRound, error) {
+	var rounds []AuctionRound
+	if err := tx.Where("product_id = ?", productID).Order("number desc").Limit(1).Find(&rounds).Error; err != nil {
+		return nil, err
+	}
+	if len(rounds) == 0 {
+		return nil, nil
+	}
+	return &rounds[0], nil
+}
+
+// openRound starts the next round of a Japanese auction.
+func openRound(tx *gorm.DB, product *Product, number int, price float64, now time.Time) error {
+	round := AuctionRound{
+		ProductID: product.ID,
+		Number:    number,
+		Price:     price,
+		OpensAt:   now,
+		ClosesAt:  now.Add(time.Duration(product.PriceStepMinutes) * time.Minute),
+	}
+	if err := tx.Create(&round).Error; err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"current_price": price}
+	if product.ClockStartedAt == nil {
+		product.ClockStartedAt = &now
+		updates["clock_started_at"] = now
+	}
+	product.CurrentPrice = price
+	return tx.Model(&Product{}).Where("id = ?", product.ID).Updates(updates).Error
+}
+
+// stayers returns the sellers who stayed in a round, earliest first.
+func stayers(tx *gorm.DB, round *AuctionRound) ([]uint, error) {
+	var responses []RoundResponse
+	if err := tx.Where("round_id = ? AND stay_in = ?", round.ID, true).Order("id").Find(&responses).Error; err != nil {
+		return nil, err
+	}
+
+	sellerIDs := make([]uint, len(responses))
+	for i, response := range responses {
+		sellerIDs[i] = response.SellerID
+	}
+	return sellerIDs, nil
+}
+
+// advanceJapaneseRound opens the first round of an open Japanese auction and
+// closes rounds whose deadline has passed. Closing a round eliminates every
+// seller who did not stay in and then either opens the next round or ends
+// the auction. When nobody stays in a later round the sellers of the
+// previous round are tied at its price and the earliest to confirm wins.
+func advanceJapaneseRound(tx *gorm.DB, product *Product, now time.Time) error {
+	if product.Status != Open || !product.acceptsOffersAt(now) {
+		return nil
+	}
+
+	round, err := currentRound(tx, product.ID)
+	if err != nil {
+		return err
+	}
+	if round == nil {
+		return openRound(tx, product, 1, product.StartPrice, now)
+	}
+	if round.ClosesAt.After(now) {
+		return nil
+	}
+
+	stayed, err := stayers(tx, round)
+	if err != nil {
+		return err
+	}
+
+	result := tx.Model(&AuctionRound{}).
+		Where("id = ? AND closed_at IS NULL", round.ID).
+		Updates(map[string]interface{}{"closed_at": now, "remaining": len(stayed)})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	eliminate := tx.Model(&RoundParticipant{}).Where("product_id = ? AND active = ?", product.ID, true)
+	if len(stayed) > 0 {
+		eliminate = eliminate.Where("seller_id NOT IN (?)", stayed)
+	}
+	if err := eliminate.Updates(map[string]interface{}{"active": false, "eliminated_in_round": round.Number}).Error; err != nil {
+		return err
+	}
+
+	switch {
+	case len(stayed) == 1:
+		return settleJapanese(tx, product, round, stayed[0], round.Price, now)
+	case len(stayed) == 0 && round.Number == 1:
+		return transitionProduct(tx, product, Expired, 0)
+	case len(stayed) == 0:
+		var previous AuctionRound
+		if err := tx.Where("product_id = ? AND number = ?", product.ID, round.Number-1).First(&previous).Error; err != nil {
+			return err
+		}
+		tied, err := stayers(tx, &previous)
+		if err != nil {
+			return err
+		}
+		return settleJapanese(tx, product, &previous, tied[0], previous.Price, now)
+	}
+
+	next := roundCents(round.Price - product.PriceStep)
+	if next <= 0 {
+		return settleJapanese(tx, product, round, stayed[0], round.Price, now)
+	}
+	return openRound(tx, product, round.Number+1, next, now)
+}
+
+// settleJapanese places the winning bid at the price of the round the winner
+// last stayed in and awards it through the shared award path. A winner
+// decided by a tie was eliminated with the others and is reinstated.
+func settleJapanese(tx *gorm.DB, product *Product, round *AuctionRound, sellerID uint, price float64, now time.Time) error {
+	err := tx.Model(&RoundParticipant{}).
+		Where("product_id = ? AND seller_id = ?", product.ID, sellerID).
+		Updates(map[string]interface{}{"active": true, "eliminated_in_round": 0}).Error
+	if err != nil {
+		return err
+	}
+
+	offer := Bid{
+		SellerID:    sellerID,
+		Price:       price,
+		Description: fmt.Sprintf("Stayed in until round %d", round.Number),
+	}
+	if err := placeBid(tx, product, &offer, now); err != nil {
+		return err
+	}
+	return awardBid(tx, product, &offer, 0, now)
+}
+
+// advanceJapaneseRounds is run by the scheduler on every tick.
+func (s *scheduler) advanceJapaneseRounds(now time.Time) {
+	var auctions []Product
+	if err := s.db.Where("status = ? AND auction_type = ?", Open, JapaneseAuction).Find(&auctions).Error; err != nil {
+		log.Println("scheduler: failed to load japanese auctions:", err)
+		return
+	}
+
+	for i := range auctions {
+		tx := s.db.Begin()
+		if err := advanceJapaneseRound(tx, &auctions[i], now); err != nil {
+			tx.Rollback()
+			log.Printf("scheduler: failed to advance rounds of product %d: %v", auctions[i].ID, err)
+			continue
+		}
+		if err := tx.Commit().Error; err != nil {
+			log.Printf("scheduler: failed to advance rounds of product %d: %v", auctions[i].ID, err)
+		}
+	}
+}
+
+type roundResponseRequest struct {
+	StayIn *bool `json:"stay_in" binding:"required"`
+}
+
+// @Summary Stay in or drop out of the current round
+// @Description Confirm staying in the current round of a Japanese auction at its announced price, or drop out. Any seller may join in the first round; later rounds are limited to sellers who stayed in every round so far. Each seller answers a round once.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body roundResponseRequest true "Whether to stay in"
+// @Security ApiKeyAuth
+// @Success 201 {object} RoundResponse
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/rounds/current [post]
+func respondToRound(c *gin.Context) {
+	productID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input roundResponseRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	if product.AuctionType != JapaneseAuction {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not a Japanese auction"})
+		return
+	}
+	if product.UserID == sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if err := checkInvited(db, &product, sellerID); err != nil {
+		respondBidError(c, err)
+		return
+	}
+
+	now := clock.Now()
+	round, err := currentRound(db, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load the current round"})
+		return
+	}
+	if product.Status != Open || round == nil || round.ClosedAt != nil || !round.ClosesAt.After(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "No round is open"})
+		return
+	}
+
+	var participant RoundParticipant
+	joined := db.Where("product_id = ? AND seller_id = ?", product.ID, sellerID).First(&participant).Error == nil
+	if round.Number > 1 && (!joined || !participant.Active) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You have been eliminated from this auction"})
+		return
+	}
+	if round.Number == 1 && joined && !participant.Active {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You have dropped out of this auction"})
+		return
+	}
+
+	tx := db.Begin()
+
+	// The unique index on round and seller rejects a second answer
+	response := RoundResponse{RoundID: round.ID, ProductID: product.ID, SellerID: sellerID, StayIn: *input.StayIn}
+	if err := tx.Create(&response).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "You have already answered this round"})
+		return
+	}
+
+	switch {
+	case response.StayIn && !joined:
+		participant = RoundParticipant{ProductID: product.ID, SellerID: sellerID, Active: true}
+		err = tx.Create(&participant).Error
+	case !response.StayIn && joined:
+		err = tx.Model(&participant).Updates(map[string]interface{}{"active": false, "eliminated_in_round": round.Number}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the answer"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, response)
+}
+
+// @Summary Get the rounds of a Japanese auction
+// @Description Get every round of a Japanese auction with its price, deadline and the answers given. The requester and admins see every answer; sellers see their own.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} AuctionRound
+// @Router /products/{id}/rounds [get]
+func getRounds(c *gin.Context) {
+	productID := c.Param("id")
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	responses := func(db *gorm.DB) *gorm.DB { return db.Order("id") }
+	if !viewer.IsAdmin && viewer.UserID != product.UserID {
+		responses = func(db *gorm.DB) *gorm.DB { return db.Where("seller_id = ?", viewer.UserID).Order("id") }
+	}
+
+	var rounds []AuctionRound
+	db.Where("product_id = ?", product.ID).Preload("Responses", responses).Order("number").Find(&rounds)
+
+	c.JSON(http.StatusOK, rounds)
+}
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+type Status int
+
+const (
+	Draft Status = iota
+	PendingReview
+	Open
+	Closed
+	Awarded
+	Cancelled
+	Expired
+)
+
+var statusNames = map[Status]string{
+	Draft:         "draft",
+	PendingReview: "pending_review",
+	Open:          "open",
+	Closed:        "closed",
+	Awarded:       "awarded",
+	Cancelled:     "cancelled",
+	Expired:       "expired",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Status) UnmarshalText(text []byte) error {
+	for status, name := range statusNames {
+		if name == string(text) {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status %q", text)
+}
+
+// transitions lists, for every status, the statuses a product may move to.
+// A closed product reopens only for a best-and-final-offer round.
+var transitions = map[Status][]Status{
+	Draft:         {PendingReview, Cancelled},
+	PendingReview: {Open, Draft, Cancelled},
+	Open:          {Closed, Awarded, Cancelled, Expired},
+	Closed:        {Open, Awarded, Cancelled, Expired},
+}
+
+// Statuses of databases from before the product lifecycle, which only knew
+// whether a product was still active or had accepted an offer.
+const (
+	legacyActive   = 0
+	legacyAccepted = 1
+)
+
+// migrateLegacyProducts maps the statuses of a database from before the
+// product lifecycle to Open and Awarded and settles the outcome of their bids.
+// Columns added since then are NULL on old rows, which queries for false
+// would skip, so they are filled in as well.
+func migrateLegacyProducts(db *gorm.DB) error {
+	steps := []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"UPDATE products SET status = CASE status WHEN ? THEN ? WHEN ? THEN ? ELSE status END",
+			[]interface{}{legacyActive, Open, legacyAccepted, Awarded}},
+		{"UPDATE products SET private = ? WHERE private IS NULL", []interface{}{false}},
+		{"UPDATE bids SET disqualified = ? WHERE disqualified IS NULL", []interface{}{false}},
+		{"UPDATE bids SET outcome = ? WHERE is_accepted = ?", []interface{}{BidWon, true}},
+		{"UPDATE bids SET outcome = CASE WHEN product_id IN (SELECT id FROM products WHERE status = ?) THEN ? ELSE ? END WHERE outcome IS NULL",
+			[]interface{}{Awarded, BidLost, BidPending}},
+	}
+
+	tx := db.Begin()
+	for _, step := range steps {
+		if err := tx.Exec(step.sql, step.args...).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+func canTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductTransition records a single status change of a product. ActorID is
+// zero when the change was made by the system rather than by a user.
+type ProductTransition struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	ProductID uint      `json:"product_id" gorm:"index"`
+	From      Status    `json:"from"`
+	To        Status    `json:"to"`
+	ActorID   uint      `json:"actor_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// transitionProduct moves the product to the given status and records who did
+// it. The update is conditional on the status the product had when it was
+// loaded, so two concurrent transitions cannot both succeed.
+func transitionProduct(tx *gorm.DB, product *Product, to Status, actorID uint) error {
+	from := product.Status
+	if !canTransition(from, to) {
+		return fmt.Errorf("cannot move product from %s to %s", from, to)
+	}
+
+	result := tx.Model(&Product{}).
+		Where("id = ? AND status = ?", product.ID, from).
+		Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("product is no longer %s", from)
+	}
+
+	transition := ProductTransition{
+		ProductID: product.ID,
+		From:      from,
+		To:        to,
+		ActorID:   actorID,
+	}
+	if err := tx.Create(&transition).Error; err != nil {
+		return err
+	}
+
+	product.Status = to
+	return nil
+}
+
+// @Summary Submit a product request for review
+// @Description Move a draft product request to pending review. Only the requester can submit it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Product
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/submit [post]
+func submitProduct(c *gin.Context) {
+	changeProductStatus(c, PendingReview, false)
+}
+
+// @Summary Close a product request
+// @Description Stop accepting offers on an open product request. The requester or an admin can close it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Product
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/close [post]
+func closeProduct(c *gin.Context) {
+	changeProductStatus(c, Closed, true)
+}
+
+// @Summary Cancel a product request
+// @Description Cancel a product request that has not been awarded yet. The requester or an admin can cancel it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Product
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/cancel [post]
+func cancelProduct(c *gin.Context) {
+	changeProductStatus(c, Cancelled, true)
+}
+
+// @Summary Expire a product request
+// @Description Mark an open or closed product request as expired without an award. Only the requester or an admin can expire it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Product
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/expire [post]
+func expireProduct(c *gin.Context) {
+	changeProductStatus(c, Expired, true)
+}
+
+// @Summary Get the status history of a product request
+// @Description Get every status transition of a product request with the actor and time of the change.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} ProductTransition
+// @Router /products/{id}/transitions [get]
+func getProductTransitions(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	var history []ProductTransition
+	db.Where("product_id = ?", product.ID).Order("id").Find(&history)
+
+	c.JSON(http.StatusOK, history)
+}
+
+// changeProductStatus is shared by the transition endpoints. The requester can
+// always act on their own product, admins only when allowAdmin is set.
+func changeProductStatus(c *gin.Context, to Status, allowAdmin bool) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+	isAdmin, _ := isAdminUser(c)
+
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID && !(allowAdmin && isAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	tx := db.Begin()
+	if err := transitionProduct(tx, &product, to, userID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, product)
+}
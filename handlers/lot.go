@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Lot is one line item of a multi-lot product request, such as the desks of
+// an office fit-out. Sellers bid on lots separately and the buyer awards each
+// lot on its own.
+type Lot struct {
+	ID        uint     `json:"id" gorm:"primary_key"`
+	ProductID uint     `json:"product_id" gorm:"index"`
+	Number    int      `json:"number"`
+	Title     string   `json:"title"`
+	Spec      string   `json:"spec,omitempty"`
+	Quantity  float64  `json:"quantity,omitempty"`
+	Unit      string   `json:"unit,omitempty"`
+	MaxBudget *float64 `json:"max_budget,omitempty"`
+	Awarded   bool     `json:"awarded"`
+
+	// Offers is only filled in when lots are listed with their offers.
+	Offers []Bid `json:"offers,omitempty" gorm:"-"`
+}
+
+// checkLots validates the lots of a new product request and numbers them.
+func checkLots(product *Product) error {
+	if len(product.Lots) > 0 && product.Quantity > 0 {
+		return fmt.Errorf("a product with lots takes its quantities from the lots")
+	}
+
+	for i := range product.Lots {
+		lot := &product.Lots[i]
+		lot.ID = 0
+		lot.Number = i + 1
+		lot.Awarded = false
+		lot.Offers = nil
+		if lot.Title == "" {
+			return fmt.Errorf("lot %d needs a title", lot.Number)
+		}
+		if lot.Quantity < 0 {
+			return fmt.Errorf("lot %d has a negative quantity", lot.Number)
+		}
+		if lot.MaxBudget != nil && *lot.MaxBudget <= 0 {
+			return fmt.Errorf("lot %d needs a max_budget greater than zero", lot.Number)
+		}
+	}
+	return nil
+}
+
+// productLots loads the lots of a product in their numbered order.
+func productLots(tx *gorm.DB, productID uint) ([]Lot, error) {
+	var lots []Lot
+	err := tx.Where("product_id = ?", productID).Order("number").Find(&lots).Error
+	return lots, err
+}
+
+// bidLot resolves the lot an offer is for. Products without lots take offers
+// for the whole product and return a nil lot.
+func bidLot(tx *gorm.DB, product *Product, offer *Bid) (*Lot, error) {
+	lots, err := productLots(tx, product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(lots) == 0 {
+		offer.LotID = nil
+		return nil, nil
+	}
+	if offer.LotID == nil {
+		return nil, &bidError{Code: "lot_required", Message: "lot_id is required on a product with lots"}
+	}
+
+	for i := range lots {
+		if lots[i].ID == *offer.LotID {
+			if lots[i].Awarded {
+				return nil, &bidError{Code: "lot_awarded", Message: "Lot has already been awarded"}
+			}
+			return &lots[i], nil
+		}
+	}
+	return nil, &bidError{Code: "unknown_lot", Message: "Lot does not belong to this product"}
+}
+
+// sameLot reports whether two optional lot IDs refer to the same lot.
+func sameLot(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// awardLot marks the lot as awarded and moves the product to Awarded once
+// every lot is. Pending bids and bundles that cover the lot are lost. The
+// update is conditional so a lot cannot be awarded twice.
+func awardLot(tx *gorm.DB, product *Product, lotID uint, actorID uint) error {
+	if product.Status != Open && product.Status != Closed {
+		return fmt.Errorf("cannot award a lot of a %s product", product.Status)
+	}
+
+	result := tx.Model(&Lot{}).
+		Where("id = ? AND product_id = ? AND awarded = ?", lotID, product.ID, false).
+		Update("awarded", true)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("lot %d has already been awarded", lotID)
+	}
+
+	err := tx.Model(&Bid{}).
+		Where("product_id = ? AND outcome = ? AND (lot_id = ? OR bundle_lot_list LIKE ?)",
+			product.ID, BidPending, lotID, bundleLotPattern(lotID)).
+		Updates(map[string]interface{}{"outcome": BidLost, "is_accepted": false}).Error
+	if err != nil {
+		return err
+	}
+
+	var remaining int
+	if err := tx.Model(&Lot{}).Where("product_id = ? AND awarded = ?", product.ID, false).Count(&remaining).Error; err != nil {
+		return err
+	}
+	if remaining == 0 {
+		return transitionProduct(tx, product, Awarded, actorID)
+	}
+	return nil
+}
+
+// @Summary List the lots of a product
+// @Description Get the lots of a multi-lot product request, each with the offers the user is allowed to see.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Lot
+// @Router /products/{id}/lots [get]
+func listLots(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	lots, err := productLots(db, product.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch lots"})
+		return
+	}
+
+	for i := range lots {
+		lotID := lots[i].ID
+		offers, err := lotOffers(db, &product, &lotID)
+		if err == nil {
+			offers, err = visibleBids(db, &product, viewer, offers)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+			return
+		}
+		lots[i].Offers = offers
+	}
+
+	c.JSON(http.StatusOK, lots)
+}
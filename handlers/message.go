@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// maxMessageAttachmentSize caps a single attachment on a message.
+const maxMessageAttachmentSize = 5 << 20
+
+// Conversation is the private channel between the requester of a product and
+// one seller. Admins can only read it while a dispute is open.
+type Conversation struct {
+	ID              uint       `json:"id" gorm:"primary_key"`
+	ProductID       uint       `json:"product_id" gorm:"unique_index:idx_conversation"`
+	SellerID        uint       `json:"seller_id" gorm:"unique_index:idx_conversation"`
+	BuyerID         uint       `json:"buyer_id"`
+	DisputeOpen     bool       `json:"dispute_open"`
+	DisputeOpenedAt *time.Time `json:"dispute_opened_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	Unread          int        `json:"unread" gorm:"-"`
+}
+
+// Message is a message in a conversation. ContactMasked is set when contact
+// details were hidden from the body.
+type Message struct {
+	ID             uint                `json:"id" gorm:"primary_key"`
+	ConversationID uint                `json:"conversation_id" gorm:"index"`
+	SenderID       uint                `json:"sender_id"`
+	Body           string              `json:"body"`
+	ContactMasked  bool                `json:"contact_masked,omitempty"`
+	ReadAt         *time.Time          `json:"read_at,omitempty"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Attachments    []MessageAttachment `json:"attachments,omitempty" gorm:"foreignkey:MessageID"`
+}
+
+// MessageAttachment is a file sent with a message. Its content lives in the
+// blob store under Hash and is only returned by the download endpoint.
+type MessageAttachment struct {
+	ID          uint   `json:"id" gorm:"primary_key"`
+	MessageID   uint   `json:"message_id" gorm:"index"`
+	FileName    string `json:"file_name"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+	Hash        string `json:"sha256"`
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	phonePattern  = regexp.MustCompile(`\+?[\d(][\d\s().-]{5,}\d`)
+	digitPattern  = regexp.MustCompile(`\d`)
+	datePattern   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	amountPattern = regexp.MustCompile(`^\d+\.\d{1,2}$`)
+)
+
+// phoneDigits counts the digits of a phone number candidate, leaving out
+// decimal amounts such as "12500.00" that merely sit next to it.
+func phoneDigits(match string) int {
+	digits := 0
+	for _, part := range strings.Fields(match) {
+		if amountPattern.MatchString(part) {
+			continue
+		}
+		digits += len(digitPattern.FindAllString(part, -1))
+	}
+	return digits
+}
+
+// maskContactDetails hides email addresses and phone numbers in text and
+// reports whether it found any. Digit runs shorter than a phone number, such
+// as quantities, decimal prices and dates, are left alone.
+func maskContactDetails(text string) (string, bool) {
+	masked := false
+	text = emailPattern.ReplaceAllStringFunc(text, func(string) string {
+		masked = true
+		return "[email hidden]"
+	})
+	text = phonePattern.ReplaceAllStringFunc(text, func(match string) string {
+		if phoneDigits(match) < 7 || datePattern.MatchString(match) {
+			return match
+		}
+		masked = true
+		return "[phone hidden]"
+	})
+	return text, masked
+}
+
+// conversationFor loads a conversation and checks that viewer may read it:
+// its buyer and seller always, admins while a dispute is open. It writes the
+// error response itself.
+func conversationFor(c *gin.Context, viewer *Token) (*Conversation, bool) {
+	var conversation Conversation
+	if err := db.Where("id = ?", c.Param("id")).First(&conversation).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Conversation not found"})
+		return nil, false
+	}
+
+	if viewer.UserID != conversation.BuyerID && viewer.UserID != conversation.SellerID &&
+		!(viewer.IsAdmin && conversation.DisputeOpen) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return nil, false
+	}
+	return &conversation, true
+}
+
+type conversationRequest struct {
+	SellerID uint `json:"seller_id"`
+}
+
+// @Summary Start a conversation about a product
+// @Description Open the conversation between the requester of a product and a seller, or return it when it exists. Sellers talk to the requester; the requester names the seller.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body conversationRequest false "Seller, for the requester"
+// @Security ApiKeyAuth
+// @Success 200 {object} Conversation
+// @Router /products/{id}/conversations [post]
+func startConversation(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input conversationRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	sellerID := userID
+	if userID == product.UserID {
+		sellerID = input.SellerID
+		if sellerID == 0 || sellerID == product.UserID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "seller_id is required"})
+			return
+		}
+	}
+
+	conversation := Conversation{ProductID: product.ID, SellerID: sellerID, BuyerID: product.UserID, CreatedAt: clock.Now()}
+	err = db.Where(Conversation{ProductID: product.ID, SellerID: sellerID}).
+		Attrs(conversation).
+		FirstOrCreate(&conversation).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start conversation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, conversation)
+}
+
+// @Summary Get my conversations
+// @Description Get the conversations of the current user with the number of unread messages in each.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Conversation
+// @Router /conversations [get]
+func getConversations(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var conversations []Conversation
+	db.Where("buyer_id = ? OR seller_id = ?", userID, userID).Order("id desc").Find(&conversations)
+
+	for i := range conversations {
+		db.Model(&Message{}).
+			Where("conversation_id = ? AND sender_id <> ? AND read_at IS NULL", conversations[i].ID, userID).
+			Count(&conversations[i].Unread)
+	}
+
+	c.JSON(http.StatusOK, conversations)
+}
+
+// unreadMessages counts the messages sent to userID that are still unread.
+func unreadMessages(tx *gorm.DB, userID uint) int {
+	conversations := tx.Model(&Conversation{}).Select("id").
+		Where("buyer_id = ? OR seller_id = ?", userID, userID).
+		QueryExpr()
+
+	var count int
+	tx.Model(&Message{}).
+		Where("conversation_id IN (?) AND sender_id <> ? AND read_at IS NULL", conversations, userID).
+		Count(&count)
+	return count
+}
+
+type attachmentInput struct {
+	FileName string `json:"file_name" binding:"required"`
+	Data     []byte `json:"data" binding:"required"`
+}
+
+type messageRequest struct {
+	Body        string            `json:"body"`
+	Attachments []attachmentInput `json:"attachments"`
+}
+
+// @Summary Send a message
+// @Description Send a message with optional base64 attachments in a conversation. Until the product is awarded, email addresses and phone numbers in the body are masked.
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Param input body messageRequest true "Message"
+// @Security ApiKeyAuth
+// @Success 201 {object} Message
+// @Router /conversations/{id}/messages [post]
+func sendMessage(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input messageRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Body == "" && len(input.Attachments) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A message needs a body or an attachment"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+
+	// Admins read disputed threads but do not write in them
+	if viewer.UserID != conversation.BuyerID && viewer.UserID != conversation.SellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", conversation.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	message := Message{ConversationID: conversation.ID, SenderID: viewer.UserID, Body: input.Body, CreatedAt: clock.Now()}
+	if product.Status != Awarded {
+		message.Body, message.ContactMasked = maskContactDetails(message.Body)
+	}
+
+	contentTypes := make([]string, len(input.Attachments))
+	for i, attachment := range input.Attachments {
+		contentTypes[i], err = sniffUpload(attachment.FileName, attachment.Data, maxMessageAttachmentSize)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx := db.Begin()
+	for i, attachment := range input.Attachments {
+		hash, err := storeBlob(tx, attachment.Data, contentTypes[i])
+		if err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment"})
+			return
+		}
+		message.Attachments = append(message.Attachments, MessageAttachment{
+			FileName:    attachment.FileName,
+			ContentType: contentTypes[i],
+			Size:        len(attachment.Data),
+			Hash:        hash,
+		})
+	}
+
+	if err := tx.Create(&message).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, message)
+}
+
+// @Summary Get the messages of a conversation
+// @Description Get the messages of a conversation, oldest first.
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Message
+// @Router /conversations/{id}/messages [get]
+func getMessages(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+
+	var messages []Message
+	db.Where("conversation_id = ?", conversation.ID).Preload("Attachments").Order("id").Find(&messages)
+
+	c.JSON(http.StatusOK, messages)
+}
+
+// @Summary Mark a conversation as read
+// @Description Mark every message the other party sent in a conversation as read.
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /conversations/{id}/read [post]
+func readConversation(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+
+	// Admins reading a dispute do not mark anything on behalf of the parties
+	if viewer.UserID == conversation.BuyerID || viewer.UserID == conversation.SellerID {
+		db.Model(&Message{}).
+			Where("conversation_id = ? AND sender_id <> ? AND read_at IS NULL", conversation.ID, viewer.UserID).
+			Update("read_at", clock.Now())
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Download a message attachment
+// @Description Download an attachment of a message in a conversation the current user can read.
+// @Produce octet-stream
+// @Param id path int true "Conversation ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Security ApiKeyAuth
+// @Success 200 {file} file
+// @Router /conversations/{id}/attachments/{attachment_id} [get]
+func downloadMessageAttachment(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+
+	var attachment MessageAttachment
+	err = db.Where("id = ? AND message_id IN (?)", c.Param("attachment_id"),
+		db.Model(&Message{}).Select("id").Where("conversation_id = ?", conversation.ID).QueryExpr()).
+		First(&attachment).Error
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	data, err := blobs.Get(attachment.Hash)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.FileName))
+	c.Data(http.StatusOK, attachment.ContentType, data)
+}
+
+// @Summary Open a dispute on a conversation
+// @Description Open a dispute on a conversation, which lets admins read it. Either party can open one.
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Conversation
+// @Router /conversations/{id}/dispute [post]
+func openDispute(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+	if viewer.UserID != conversation.BuyerID && viewer.UserID != conversation.SellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	if !conversation.DisputeOpen {
+		now := clock.Now()
+		db.Model(conversation).Updates(map[string]interface{}{"dispute_open": true, "dispute_opened_at": now})
+		conversation.DisputeOpen = true
+		conversation.DisputeOpenedAt = &now
+	}
+
+	c.JSON(http.StatusOK, conversation)
+}
+
+// @Summary Close a dispute on a conversation
+// @Description Close the dispute on a conversation, after which admins can no longer read it. Only admins can close disputes.
+// @Accept json
+// @Produce json
+// @Param id path int true "Conversation ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Conversation
+// @Router /conversations/{id}/dispute/close [post]
+func closeDispute(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+	if !viewer.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	conversation, ok := conversationFor(c, viewer)
+	if !ok {
+		return
+	}
+
+	db.Model(conversation).Updates(map[string]interface{}{"dispute_open": false, "dispute_opened_at": nil})
+	conversation.DisputeOpen = false
+	conversation.DisputeOpenedAt = nil
+
+	c.JSON(http.StatusOK, conversation)
+}
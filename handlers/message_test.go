@@ -0,0 +1,29 @@
+package handlers
+
+import "testing"
+
+func TestMaskContactDetails(t *testing.T) {
+	tests := []struct {
+		text   string
+		want   string
+		masked bool
+	}{
+		{"Mail me at jane.doe@example.com", "Mail me at [email hidden]", true},
+		{"Call +1 (555) 123-4567 today", "Call [phone hidden] today", true},
+		{"My number is 0912 345 6789", "My number is [phone hidden]", true},
+		{"Ring 555.123.4567", "Ring [phone hidden]", true},
+		{"I can do it for 12500.00", "I can do it for 12500.00", false},
+		{"1000 units 250.00 each", "1000 units 250.00 each", false},
+		{"1000 250.00", "1000 250.00", false},
+		{"Total 1250000.50 for 1200 units", "Total 1250000.50 for 1200 units", false},
+		{"Delivery by 2024-05-01", "Delivery by 2024-05-01", false},
+		{"We need 120 pieces", "We need 120 pieces", false},
+	}
+
+	for _, tt := range tests {
+		got, masked := maskContactDetails(tt.text)
+		if got != tt.want || masked != tt.masked {
+			t.Errorf("maskContactDetails(%q) = %q, %v; want %q, %v", tt.text, got, masked, tt.want, tt.masked)
+		}
+	}
+}
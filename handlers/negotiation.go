@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// counterOfferTTL is how long a counter-offer stays open when its author does
+// not say otherwise.
+const counterOfferTTL = 48 * time.Hour
+
+// Party is the side of a negotiation that made a counter-offer.
+type Party string
+
+const (
+	BuyerParty  Party = "buyer"
+	SellerParty Party = "seller"
+)
+
+// CounterOfferStatus is where a step of a negotiation stands. Only the
+// latest step of a thread can be open.
+type CounterOfferStatus string
+
+const (
+	CounterOpen      CounterOfferStatus = "open"
+	CounterAccepted  CounterOfferStatus = "accepted"
+	CounterDeclined  CounterOfferStatus = "declined"
+	CounterCountered CounterOfferStatus = "countered"
+	CounterExpired   CounterOfferStatus = "expired"
+)
+
+// CounterOffer is one step of the negotiation thread attached to a bid. The
+// buyer opens the thread; after that the parties take turns until one of
+// them accepts or declines, or a step expires.
+type CounterOffer struct {
+	ID          uint               `json:"id" gorm:"primary_key"`
+	BidID       uint               `json:"bid_id" gorm:"index"`
+	ProductID   uint               `json:"product_id"`
+	AuthorID    uint               `json:"author_id"`
+	Party       Party              `json:"party"`
+	Price       float64            `json:"price"`
+	Terms       string             `json:"terms"`
+	Status      CounterOfferStatus `json:"status"`
+	ExpiresAt   time.Time          `json:"expires_at"`
+	RespondedAt *time.Time         `json:"responded_at,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+type counterOfferRequest struct {
+	Price            float64 `json:"price" binding:"required"`
+	Terms            string  `json:"terms"`
+	ExpiresInMinutes int     `json:"expires_in_minutes"`
+}
+
+// checkNegotiable refuses negotiations on bids that cannot be awarded, and on
+// sealed bids before the buyer may see them.
+func checkNegotiable(product *Product, bid *Bid, now time.Time) error {
+	if product.Status != Open && product.Status != Closed {
+		return fmt.Errorf("offers cannot be negotiated on a %s product", product.Status)
+	}
+	if product.announcesPrice() {
+		return fmt.Errorf("offers cannot be negotiated in a %s auction", product.AuctionType)
+	}
+	if product.isSealed() && product.isBiddingPhase() || product.inRevealPhase(now) {
+		return fmt.Errorf("sealed offers cannot be negotiated before they are opened")
+	}
+	if bid.Outcome != BidPending || bid.IsDiscarded || bid.Disqualified {
+		return fmt.Errorf("offer %d can no longer be negotiated", bid.ID)
+	}
+	return checkAwardable(product, bid, now)
+}
+
+// latestCounterOffer returns the latest step of the negotiation on a bid, or
+// nil when there is none.
+func latestCounterOffer(tx *gorm.DB, bidID uint) (*CounterOffer, error) {
+	var steps []CounterOffer
+	if err := tx.Where("bid_id = ?", bidID).Order("id desc").Limit(1).Find(&steps).Error; err != nil {
+		return nil, err
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+	return &steps[0], nil
+}
+
+// closeCounterOffer moves an open step to status. It fails when the step was
+// answered or expired in the meantime.
+func closeCounterOffer(tx *gorm.DB, step *CounterOffer, status CounterOfferStatus, now time.Time) error {
+	result := tx.Model(&CounterOffer{}).
+		Where("id = ? AND status = ?", step.ID, CounterOpen).
+		Updates(map[string]interface{}{"status": status, "responded_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("counter-offer %d is no longer open", step.ID)
+	}
+
+	step.Status = status
+	step.RespondedAt = &now
+	return nil
+}
+
+// negotiationParty loads the bid and product of a negotiation request and
+// tells which party the user is. It writes the error response itself.
+func negotiationParty(c *gin.Context) (*Bid, *Product, Party, uint, bool) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return nil, nil, "", 0, false
+	}
+
+	var offer Bid
+	if err := db.Preload("Attributes").Where("id = ?", c.Param("id")).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return nil, nil, "", 0, false
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil, nil, "", 0, false
+	}
+
+	switch userID {
+	case product.UserID:
+		return &offer, &product, BuyerParty, userID, true
+	case offer.SellerID:
+		return &offer, &product, SellerParty, userID, true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+	return nil, nil, "", 0, false
+}
+
+// openStepFor returns the open step of the thread that awaits an answer from
+// party. Steps past their expiry are expired on the way.
+func openStepFor(tx *gorm.DB, bidID uint, party Party, now time.Time) (*CounterOffer, error) {
+	step, err := latestCounterOffer(tx, bidID)
+	if err != nil {
+		return nil, err
+	}
+	if step == nil || step.Status != CounterOpen {
+		return nil, nil
+	}
+	if !step.ExpiresAt.After(now) {
+		return nil, closeCounterOffer(tx, step, CounterExpired, now)
+	}
+	if step.Party == party {
+		return nil, fmt.Errorf("waiting for the other party to answer")
+	}
+	return step, nil
+}
+
+// @Summary Make a counter-offer on an offer
+// @Description Reply to an offer with a price and terms. The requester opens the negotiation; after that the requester and the seller take turns countering the open step. Each step expires after expires_in_minutes, 48 hours by default.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Param input body counterOfferRequest true "Counter-offer"
+// @Security ApiKeyAuth
+// @Success 201 {object} CounterOffer
+// @Failure 409 {object} map[string]interface{}
+// @Router /offers/{id}/counter [post]
+func counterOffer(c *gin.Context) {
+	offer, product, party, userID, ok := negotiationParty(c)
+	if !ok {
+		return
+	}
+
+	var input counterOfferRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Price <= 0 || input.ExpiresInMinutes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Price and expiry must be positive"})
+		return
+	}
+
+	now := clock.Now()
+	if err := checkNegotiable(product, offer, now); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := db.Begin()
+	open, err := openStepFor(tx, offer.ID, party, now)
+	if err == nil && open != nil {
+		err = closeCounterOffer(tx, open, CounterCountered, now)
+	}
+	if err == nil && open == nil && party == SellerParty {
+		err = fmt.Errorf("there is no counter-offer to answer")
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	ttl := counterOfferTTL
+	if input.ExpiresInMinutes > 0 {
+		ttl = time.Duration(input.ExpiresInMinutes) * time.Minute
+	}
+	step := CounterOffer{
+		BidID:     offer.ID,
+		ProductID: product.ID,
+		AuthorID:  userID,
+		Party:     party,
+		Price:     input.Price,
+		Terms:     input.Terms,
+		Status:    CounterOpen,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if err := tx.Create(&step).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save counter-offer"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, step)
+}
+
+// @Summary Accept the open counter-offer
+// @Description Accept the counter-offer the other party made. The offer takes the agreed price and is awarded through the normal award path.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} CounterOffer
+// @Failure 409 {object} map[string]interface{}
+// @Router /offers/{id}/counter/accept [post]
+func acceptCounterOffer(c *gin.Context) {
+	offer, product, party, userID, ok := negotiationParty(c)
+	if !ok {
+		return
+	}
+
+	now := clock.Now()
+	if err := checkNegotiable(product, offer, now); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx := db.Begin()
+	step, err := openStepFor(tx, offer.ID, party, now)
+	if err == nil && step == nil {
+		err = fmt.Errorf("there is no counter-offer to accept")
+	}
+	if err == nil {
+		err = closeCounterOffer(tx, step, CounterAccepted, now)
+	}
+	if err == nil {
+		err = bumpBidVersion(tx, offer, map[string]interface{}{"price": step.Price})
+	}
+	if err == nil {
+		offer.Price = step.Price
+		err = recordBidVersion(tx, offer, ChangeNegotiated, now)
+	}
+	if err == nil {
+		// The agreed price is the contract price, even under second-price
+		// awards; bundles always pay their own price anyway
+		if offer.isBundle() {
+			err = awardBid(tx, product, offer, userID, now)
+		} else {
+			line := awardLine{Bid: offer, Quantity: offer.QuantityOffered, Negotiated: true}
+			err = awardBids(tx, product, []awardLine{line}, userID, now)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to award offer"})
+		return
+	}
+
+	c.JSON(http.StatusOK, step)
+}
+
+// @Summary Decline the open counter-offer
+// @Description Decline the counter-offer the other party made. This ends the negotiation; the offer itself stays as it was.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} CounterOffer
+// @Failure 409 {object} map[string]interface{}
+// @Router /offers/{id}/counter/decline [post]
+func declineCounterOffer(c *gin.Context) {
+	offer, _, party, _, ok := negotiationParty(c)
+	if !ok {
+		return
+	}
+
+	now := clock.Now()
+	tx := db.Begin()
+	step, err := openStepFor(tx, offer.ID, party, now)
+	if err == nil && step == nil {
+		err = fmt.Errorf("there is no counter-offer to decline")
+	}
+	if err == nil {
+		err = closeCounterOffer(tx, step, CounterDeclined, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, step)
+}
+
+// @Summary Get the negotiation on an offer
+// @Description Get every counter-offer made on an offer, oldest first. Only the requester, the seller and admins can see it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} CounterOffer
+// @Router /offers/{id}/negotiation [get]
+func getNegotiation(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var offer Bid
+	if err := db.Where("id = ?", c.Param("id")).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if !viewer.IsAdmin && viewer.UserID != product.UserID && viewer.UserID != offer.SellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var thread []CounterOffer
+	db.Where("bid_id = ?", offer.ID).Order("id").Find(&thread)
+
+	c.JSON(http.StatusOK, thread)
+}
+
+// expireCounterOffers is run by the scheduler on every tick so that open
+// steps past their expiry show as expired even when nobody answers them.
+func (s *scheduler) expireCounterOffers(now time.Time) {
+	var open []CounterOffer
+	if err := s.db.Where("status = ?", CounterOpen).Find(&open).Error; err != nil {
+		log.Println("scheduler: failed to load counter-offers:", err)
+		return
+	}
+
+	for i := range open {
+		if open[i].ExpiresAt.After(now) {
+			continue
+		}
+		if err := closeCounterOffer(s.db, &open[i], CounterExpired, now); err != nil {
+			log.Printf("scheduler: failed to expire counter-offer %d: %v", open[i].ID, err)
+		}
+	}
+}
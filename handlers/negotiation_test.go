@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAcceptedCounterOfferKeepsNegotiatedPrice(t *testing.T) {
+	now := setupTestDB(t).Now()
+
+	product := Product{Title: "Pipes", UserID: 1, Status: Closed, AwardPricing: PaySecondPrice}
+	mustCreate(t, &product)
+	offer := Bid{ProductID: product.ID, SellerID: 2, Price: 10, Version: 1}
+	runnerUp := Bid{ProductID: product.ID, SellerID: 3, Price: 14, Version: 1}
+	mustCreate(t, &offer)
+	mustCreate(t, &runnerUp)
+	mustCreate(t, &CounterOffer{BidID: offer.ID, ProductID: product.ID, AuthorID: 1, Party: BuyerParty,
+		Price: 9, Status: CounterOpen, ExpiresAt: now.Add(time.Hour), CreatedAt: now})
+
+	w := call(acceptCounterOffer, http.MethodPost, idParam(offer.ID), nil, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusOK)
+
+	var award Award
+	if err := db.Where("bid_id = ?", offer.ID).First(&award).Error; err != nil {
+		t.Fatal(err)
+	}
+	if award.ContractPrice != 9 {
+		t.Errorf("contract price = %g, want the negotiated 9", award.ContractPrice)
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// NotificationKind says what a notification is about.
+type NotificationKind string
+
+const (
+	// NotifyValidityRequest asks a seller to extend the validity of a bid.
+	NotifyValidityRequest NotificationKind = "validity_request"
+	// NotifyInvitation invites a seller to a private product.
+	NotifyInvitation NotificationKind = "invitation"
+	// NotifyAnswer tells a seller their question was answered.
+	NotifyAnswer NotificationKind = "answer"
+	// NotifyNewRequest tells a seller about a product request matching
+	// their categories, subscriptions or saved searches.
+	NotifyNewRequest NotificationKind = "new_request"
+)
+
+// Notification is a message in a user's in-app inbox.
+type Notification struct {
+	ID        uint             `json:"id" gorm:"primary_key"`
+	UserID    uint             `json:"user_id" gorm:"index"`
+	Kind      NotificationKind `json:"kind"`
+	ProductID uint             `json:"product_id,omitempty"`
+	BidID     *uint            `json:"bid_id,omitempty"`
+	Message   string           `json:"message"`
+	ReadAt    *time.Time       `json:"read_at,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+// notify puts a notification in the inbox of userID.
+func notify(tx *gorm.DB, userID uint, kind NotificationKind, productID uint, bidID *uint, message string) error {
+	notification := Notification{
+		UserID:    userID,
+		Kind:      kind,
+		ProductID: productID,
+		BidID:     bidID,
+		Message:   message,
+		CreatedAt: clock.Now(),
+	}
+	return tx.Create(&notification).Error
+}
+
+// @Summary Get notifications
+// @Description Get the notifications of the current user, newest first. Pass unread=true for unread ones only.
+// @Accept json
+// @Produce json
+// @Param unread query bool false "Only unread notifications"
+// @Security ApiKeyAuth
+// @Success 200 {array} Notification
+// @Router /profile/notifications [get]
+func getNotifications(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	query := db.Where("user_id = ?", userID)
+	if c.Query("unread") == "true" {
+		query = query.Where("read_at IS NULL")
+	}
+
+	var notifications []Notification
+	query.Order("id desc").Find(&notifications)
+
+	c.JSON(http.StatusOK, notifications)
+}
+
+// @Summary Mark a notification as read
+// @Description Mark one of the current user's notifications as read.
+// @Accept json
+// @Produce json
+// @Param id path int true "Notification ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /profile/notifications/{id}/read [post]
+func readNotification(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var notification Notification
+	if err := db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&notification).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		db.Model(&notification).Update("read_at", clock.Now())
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// DecrementType tells how MinDecrement of a product is measured.
+type DecrementType string
+
+const (
+	// DecrementAbsolute takes MinDecrement as an amount of money.
+	DecrementAbsolute DecrementType = "absolute"
+	// DecrementPercent takes MinDecrement as a percentage of the best bid.
+	DecrementPercent DecrementType = "percent"
+)
+
+// bidError explains why a price was refused. MaxAcceptablePrice is the
+// highest price the product would currently accept, if there is one. Conflict
+// marks refusals caused by a concurrent change rather than by the offer itself.
+type bidError struct {
+	Code               string
+	Message            string
+	MaxAcceptablePrice *float64
+	Conflict           bool
+}
+
+func (e *bidError) Error() string {
+	return e.Message
+}
+
+func (e *bidError) response() gin.H {
+	body := gin.H{"error": e.Message, "code": e.Code}
+	if e.MaxAcceptablePrice != nil {
+		body["max_acceptable_price"] = *e.MaxAcceptablePrice
+	}
+	return body
+}
+
+// decrementBelow returns the lowest amount a new bid has to undercut price by.
+func (p *Product) decrementBelow(price float64) float64 {
+	if p.DecrementType == DecrementPercent {
+		return price * p.MinDecrement / 100
+	}
+	return p.MinDecrement
+}
+
+// enforcesDecrement reports whether new bids are compared with the best bid.
+// Sealed and rank-only auctions skip the check so that refusals do not leak
+// the best price, and final offers are independent of each other.
+func (p *Product) enforcesDecrement() bool {
+	return p.MinDecrement > 0 && !p.isSealed() && p.AuctionType != RankOnlyAuction && !p.BafoRound
+}
+
+// budgetFor returns the budget that applies to a bid on the product or lot.
+func budgetFor(product *Product, lot *Lot) *float64 {
+	if lot != nil && lot.MaxBudget != nil {
+		return lot.MaxBudget
+	}
+	return product.MaxBudget
+}
+
+// maxAcceptablePrice returns the highest price a new bid on the product or
+// lot may have, or nil when there is no limit.
+func maxAcceptablePrice(tx *gorm.DB, product *Product, lot *Lot, now time.Time) (*float64, error) {
+	var limit *float64
+	if budget := budgetFor(product, lot); budget != nil {
+		ceiling := *budget
+		limit = &ceiling
+	}
+
+	if !product.enforcesDecrement() {
+		return limit, nil
+	}
+
+	var lotID *uint
+	if lot != nil {
+		lotID = &lot.ID
+	}
+	bids, err := validBids(tx, product, lotID, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(bids) > 0 {
+		best := bids[0].Price
+		ceiling := roundCents(best - product.decrementBelow(best))
+		if limit == nil || ceiling < *limit {
+			limit = &ceiling
+		}
+	}
+
+	return limit, nil
+}
+
+// checkOfferPrice refuses prices that are not positive, above the buyer's
+// budget or not low enough compared with the current best bid.
+func checkOfferPrice(tx *gorm.DB, product *Product, lot *Lot, price float64, now time.Time) error {
+	limit, err := maxAcceptablePrice(tx, product, lot, now)
+	if err != nil {
+		return err
+	}
+
+	if price <= 0 {
+		return &bidError{Code: "price_not_positive", Message: "Price must be greater than zero", MaxAcceptablePrice: limit}
+	}
+	if budget := budgetFor(product, lot); budget != nil && price > *budget {
+		return &bidError{Code: "price_above_budget", Message: "Price is above the buyer's budget", MaxAcceptablePrice: limit}
+	}
+	if limit != nil && price > *limit {
+		return &bidError{Code: "decrement_too_small", Message: "Price does not undercut the best offer by the minimum decrement", MaxAcceptablePrice: limit}
+	}
+	return nil
+}
+
+func roundCents(amount float64) float64 {
+	return math.Floor(amount*100+1e-9) / 100
+}
+
+// checkBudgetSettings validates the reserve price and decrement of a new
+// product request.
+func checkBudgetSettings(product *Product) error {
+	if product.MaxBudget != nil && *product.MaxBudget <= 0 {
+		return fmt.Errorf("max_budget must be greater than zero")
+	}
+	if product.MinDecrement < 0 {
+		return fmt.Errorf("min_decrement must not be negative")
+	}
+
+	switch product.DecrementType {
+	case "":
+		product.DecrementType = DecrementAbsolute
+	case DecrementAbsolute:
+	case DecrementPercent:
+		if product.MinDecrement >= 100 {
+			return fmt.Errorf("a percentage min_decrement must be below 100")
+		}
+	default:
+		return fmt.Errorf("unknown decrement type %q", product.DecrementType)
+	}
+	return nil
+}
@@ -1,29 +1,101 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	_ "uniproject/docs"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 )
 
-type Status int
+type Product struct {
+	ID          uint        `json:"id" gorm:"primary_key"`
+	Title       string      `json:"title,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Status      Status      `json:"status"`
+	IsDiscarded bool        `json:"is_discarded"`
+	UserID      uint        `json:"user_id,omitempty"`
+	User        *User       `json:"user,omitempty"`
+	Quantity    float64     `json:"quantity,omitempty"`
+	Unit        string      `json:"unit,omitempty"`
+	OpensAt     *time.Time  `json:"opens_at,omitempty"`
+	ClosesAt    *time.Time  `json:"closes_at,omitempty"`
+	AwardPolicy AwardPolicy `json:"award_policy,omitempty"`
+	AuctionType AuctionType `json:"auction_type,omitempty"`
+	CategoryID  uint        `json:"category_id" gorm:"index"`
 
-const (
-	Active Status = iota
-	Accepted
-)
+	// AwardPricing decides the price winners are paid: their own bid or
+	// the price of the runner-up.
+	AwardPricing AwardPricing `json:"award_pricing,omitempty"`
 
-type Product struct {
-	ID          uint   `json:"id" gorm:"primary_key"`
-	Title       string `json:"title,omitempty"`
-	Description string `json:"description,omitempty"`
-	Status      Status `json:"status,omitempty"`
-	IsDiscarded bool   `json:"is_discarded"`
-	UserID      uint   `json:"user_id,omitempty"`
-	User        *User  `json:"user,omitempty"`
+	// MaxBudget is the highest price the buyer is willing to pay. New bids
+	// must undercut the best bid by MinDecrement, measured as DecrementType.
+	MaxBudget     *float64      `json:"max_budget,omitempty"`
+	MinDecrement  float64       `json:"min_decrement"`
+	DecrementType DecrementType `json:"decrement_type,omitempty"`
+
+	// Attributes and PriceWeight form the scoring model. Without attributes
+	// bids are ranked by price alone.
+	Attributes  []ScoringAttribute `json:"attributes,omitempty" gorm:"foreignkey:ProductID"`
+	PriceWeight float64            `json:"price_weight"`
+
+	// Lots turns the request into a multi-lot RFQ.
+	Lots []Lot `json:"lots,omitempty" gorm:"foreignkey:ProductID"`
+
+	// Price schedule of Dutch and Japanese auctions. A Dutch price rises by
+	// PriceStep every PriceStepMinutes; a Japanese round lasts
+	// PriceStepMinutes and announces a price PriceStep below the last one.
+	// CurrentPrice is the price announced by the scheduler since
+	// ClockStartedAt.
+	StartPrice       float64    `json:"start_price,omitempty"`
+	PriceStep        float64    `json:"price_step,omitempty"`
+	PriceStepMinutes int        `json:"price_step_minutes,omitempty"`
+	CurrentPrice     float64    `json:"current_price,omitempty"`
+	ClockStartedAt   *time.Time `json:"clock_started_at,omitempty"`
+
+	// WithdrawalRule decides whether sellers may withdraw their bids, and
+	// WithdrawalCutoffMinutes how long before ClosesAt they must do so.
+	WithdrawalRule          WithdrawalRule `json:"withdrawal_rule,omitempty"`
+	WithdrawalCutoffMinutes int            `json:"withdrawal_cutoff_minutes,omitempty"`
+
+	// Private products are only visible to invited sellers; see Invitation.
+	Private bool `json:"private,omitempty"`
+
+	// BafoRound is set once the buyer shortlisted sellers for a best and
+	// final offer. From then on only final offers count.
+	BafoRound bool `json:"bafo_round,omitempty"`
+
+	// RevealClosesAt ends the reveal phase of a commit-reveal auction.
+	// RevealsSettled is set once the scheduler settled the reveals; the
+	// column defaults to false so rows from before it existed are settled too.
+	RevealClosesAt *time.Time `json:"reveal_closes_at,omitempty"`
+	RevealsSettled bool       `json:"reveals_settled,omitempty" gorm:"not null;default:false"`
+
+	// Soft close: a bid within the last ExtensionWindowMinutes moves ClosesAt
+	// out by ExtensionMinutes, at most MaxExtensions times.
+	ExtensionWindowMinutes int `json:"extension_window_minutes"`
+	ExtensionMinutes       int `json:"extension_minutes"`
+	MaxExtensions          int `json:"max_extensions"`
+	ExtensionCount         int `json:"extension_count"`
+
+	// AnswerExtensionMinutes is the least time bidders get after an answer
+	// to a question is published.
+	AnswerExtensionMinutes int `json:"answer_extension_minutes,omitempty"`
+}
+
+// acceptsOffersAt reports whether t falls inside the bidding window.
+func (p *Product) acceptsOffersAt(t time.Time) bool {
+	if p.OpensAt != nil && t.Before(*p.OpensAt) {
+		return false
+	}
+	if p.ClosesAt != nil && !t.Before(*p.ClosesAt) {
+		return false
+	}
+	return true
 }
 
 // @Summary Register a new product
@@ -47,28 +119,125 @@ func requestProduct(c *gin.Context) {
 		return
 	}
 
-	// Set default status to 'active'
-	product.Status = Active
+	if err := prepareProductRequest(&product); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCategory(db, &product); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// New requests start as drafts until the requester submits them
+	product.Status = Draft
 	product.UserID = buyerID
 
 	// Create the product
-	db.Create(&product)
+	if err := db.Create(&product).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
 
 	c.JSON(http.StatusCreated, product)
 }
 
+// prepareProductRequest validates the auction settings of a new product
+// request and fills in their defaults.
+func prepareProductRequest(product *Product) error {
+	if product.Quantity < 0 {
+		return fmt.Errorf("quantity must not be negative")
+	}
+	if product.Quantity == 0 {
+		product.Unit = ""
+	}
+
+	// Validate the bidding window
+	if product.OpensAt != nil && product.ClosesAt != nil && !product.ClosesAt.After(*product.OpensAt) {
+		return fmt.Errorf("closes_at must be after opens_at")
+	}
+	if product.ClosesAt != nil && !product.ClosesAt.After(clock.Now()) {
+		return fmt.Errorf("closes_at must be in the future")
+	}
+
+	if product.ExtensionWindowMinutes < 0 || product.ExtensionMinutes < 0 || product.MaxExtensions < 0 || product.AnswerExtensionMinutes < 0 {
+		return fmt.Errorf("soft close settings must not be negative")
+	}
+	product.ExtensionCount = 0
+
+	if err := checkBudgetSettings(product); err != nil {
+		return err
+	}
+
+	if err := checkScoringModel(product); err != nil {
+		return err
+	}
+
+	if err := checkLots(product); err != nil {
+		return err
+	}
+
+	switch product.AwardPolicy {
+	case "":
+		product.AwardPolicy = AwardManual
+	case AwardManual, AwardLowestPrice:
+	default:
+		return fmt.Errorf("unknown award policy %q", product.AwardPolicy)
+	}
+
+	if err := checkWithdrawalSettings(product); err != nil {
+		return err
+	}
+
+	switch product.AwardPricing {
+	case "":
+		product.AwardPricing = PayOwnBid
+	case PayOwnBid, PaySecondPrice:
+	default:
+		return fmt.Errorf("unknown award pricing %q", product.AwardPricing)
+	}
+
+	switch product.AuctionType {
+	case "":
+		product.AuctionType = OpenAuction
+	case OpenAuction, SealedAuction, RankOnlyAuction, DutchAuction, JapaneseAuction:
+	case CommitRevealAuction:
+		if product.ClosesAt == nil || product.RevealClosesAt == nil {
+			return fmt.Errorf("commit-reveal auctions need closes_at and reveal_closes_at")
+		}
+		if !product.RevealClosesAt.After(*product.ClosesAt) {
+			return fmt.Errorf("reveal_closes_at must be after closes_at")
+		}
+	default:
+		return fmt.Errorf("unknown auction type %q", product.AuctionType)
+	}
+	if product.AuctionType != CommitRevealAuction {
+		product.RevealClosesAt = nil
+	}
+	product.RevealsSettled = false
+	product.BafoRound = false
+	if err := checkPriceSchedule(product); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // @Summary List all products
-// @Description Get a list of all products with optional sorting and filtering.
+// @Description Get a list of all products with optional sorting and filtering. Drafts and requests pending review are only listed for their requester and admins, private products also for invited sellers.
 // @Accept json
 // @Produce json
 // @Param sort query string false "Sort field (e.g., title, price)"
 // @Param filter query string false "Filter products by name"
 // @Param user_id query int false "Filter products by user id"
+// @Param category_id query int false "Filter products by category, including its subcategories"
 // @Success 200 {array} Product
 // @Router /products [get]
 func listProducts(c *gin.Context) {
 	var products []Product
-	query := db
+
+	// Private products are only listed for those invited to them
+	viewer, _ := viewerFromContext(c)
+	query := visibleProducts(db, viewer)
 
 	// Sorting
 	sortParam := c.Query("sort")
@@ -88,7 +257,20 @@ func listProducts(c *gin.Context) {
 		query = query.Where("user_id = ?", userID)
 	}
 
-	query.Find(&products)
+	// A category matches its subcategories too
+	categoryParam := c.Query("category_id")
+	if categoryParam != "" {
+		var category Category
+		if err := db.Where("id = ?", categoryParam).First(&category).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category not found"})
+			return
+		}
+		query = query.Where("category_id IN (?)", subtreeIDs(db, &category))
+	}
+
+	query.Preload("Attributes").Preload("Lots", func(db *gorm.DB) *gorm.DB {
+		return db.Order("number")
+	}).Find(&products)
 
 	c.JSON(http.StatusOK, products)
 }
@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ProxyAgent bids on behalf of a seller. Whenever a competitor undercuts the
+// seller, the agent places the smallest valid counter-bid, never going below
+// FloorPrice. A seller has at most one active agent per product.
+type ProxyAgent struct {
+	ID         uint      `json:"id" gorm:"primary_key"`
+	ProductID  uint      `json:"product_id" gorm:"index"`
+	SellerID   uint      `json:"seller_id"`
+	FloorPrice float64   `json:"floor_price"`
+	Step       float64   `json:"step"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// runProxyAgents lets the proxy agents of the product respond to its current
+// bids. Agents are ordered by floor price and then by registration, so the
+// agent that can go lowest leads and, among agents with the same floor, the
+// earliest one wins. Only the leading agent bids: it undercuts the best price
+// offered by anyone else, where the floor of a rival agent counts as an offer
+// that agent would make. Rival agents therefore never bid against the leader.
+func runProxyAgents(tx *gorm.DB, product *Product, now time.Time) error {
+	if product.Status != Open || product.isSealed() || product.BafoRound {
+		return nil
+	}
+
+	var agents []ProxyAgent
+	err := tx.Where("product_id = ? AND active = ?", product.ID, true).
+		Order("floor_price, created_at, id").
+		Find(&agents).Error
+	if err != nil || len(agents) == 0 {
+		return err
+	}
+	leader := agents[0]
+
+	bids, err := validBids(tx, product, nil, now)
+	if err != nil {
+		return err
+	}
+
+	// Find the price the leader has to beat
+	competitor := math.Inf(1)
+	fromRivalAgent := false
+	for _, bid := range bids {
+		if bid.SellerID != leader.SellerID {
+			competitor = bid.Price
+			break
+		}
+	}
+	if len(agents) > 1 && agents[1].FloorPrice < competitor {
+		competitor = agents[1].FloorPrice
+		fromRivalAgent = true
+	}
+	if math.IsInf(competitor, 1) {
+		return nil
+	}
+
+	// Nothing to do while the leader is already ahead. A tie with a rival
+	// agent goes to the leader because it was registered first.
+	for _, bid := range bids {
+		if bid.SellerID == leader.SellerID {
+			if bid.Price < competitor || (bid.Price == competitor && fromRivalAgent) {
+				return nil
+			}
+			break
+		}
+	}
+
+	price := roundCents(competitor - math.Max(leader.Step, product.decrementBelow(competitor)))
+	if price < leader.FloorPrice {
+		price = leader.FloorPrice
+	}
+	if price > competitor || (price == competitor && !fromRivalAgent) {
+		return nil
+	}
+
+	agentID := leader.ID
+	offer := Bid{
+		SellerID:     leader.SellerID,
+		Price:        price,
+		Description:  "Placed by proxy agent",
+		ProxyAgentID: &agentID,
+	}
+	if err := placeBid(tx, product, &offer, now); err != nil {
+		// A counter-bid the rules refuse simply means the agent is out
+		if _, ok := err.(*bidError); ok {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// @Summary Register a proxy agent
+// @Description Register a proxy agent that bids for the seller down to a floor price. It replaces any agent the seller already has on the product.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body ProxyAgent true "Floor price and step"
+// @Security ApiKeyAuth
+// @Success 201 {object} ProxyAgent
+// @Failure 400 {object} map[string]interface{}
+// @Router /products/{id}/agents [post]
+func registerProxyAgent(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var agent ProxyAgent
+	if err := c.ShouldBindJSON(&agent); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if product.Status != Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product is not open for offers"})
+		return
+	}
+	if err := checkInvited(db, &product, sellerID); err != nil {
+		respondBidError(c, err)
+		return
+	}
+	if product.isSealed() || product.announcesPrice() || product.BafoRound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Proxy agents are not available in this auction type"})
+		return
+	}
+	if attrs, _ := productAttributes(db, product.ID); len(attrs) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Proxy agents are not available on scored products"})
+		return
+	}
+	if lots, _ := productLots(db, product.ID); len(lots) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Proxy agents are not available on products with lots"})
+		return
+	}
+	if agent.FloorPrice <= 0 || agent.Step <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "floor_price and step must be greater than zero"})
+		return
+	}
+
+	now := clock.Now()
+	agent.ID = 0
+	agent.ProductID = product.ID
+	agent.SellerID = sellerID
+	agent.Active = true
+	agent.CreatedAt = now
+
+	tx := db.Begin()
+
+	// Replace the seller's previous agent
+	err = tx.Model(&ProxyAgent{}).
+		Where("product_id = ? AND seller_id = ? AND active = ?", product.ID, sellerID, true).
+		Update("active", false).Error
+	if err == nil {
+		err = tx.Create(&agent).Error
+	}
+	if err == nil {
+		err = runProxyAgents(tx, &product, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register proxy agent"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusCreated, agent)
+}
+
+// @Summary List your proxy agents
+// @Description Get the proxy agents the seller registered on a product.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} ProxyAgent
+// @Router /products/{id}/agents [get]
+func getProxyAgents(c *gin.Context) {
+	productID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var agents []ProxyAgent
+	db.Where("product_id = ? AND seller_id = ?", productID, sellerID).Order("id").Find(&agents)
+
+	c.JSON(http.StatusOK, agents)
+}
+
+// @Summary Cancel a proxy agent
+// @Description Stop a proxy agent from bidding. Bids it already placed stay in place.
+// @Accept json
+// @Produce json
+// @Param id path int true "Agent ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /agents/{id} [delete]
+func cancelProxyAgent(c *gin.Context) {
+	agentID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var agent ProxyAgent
+	if err := db.Where("id = ?", agentID).First(&agent).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return
+	}
+
+	if agent.SellerID != sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", agent.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	// The next agent in line may now have to respond
+	tx := db.Begin()
+	err = tx.Model(&agent).Update("active", false).Error
+	if err == nil {
+		err = runProxyAgents(tx, &product, clock.Now())
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel proxy agent"})
+		return
+	}
+	tx.Commit()
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// AnswerVisibility decides who can read the answer to a question.
+type AnswerVisibility string
+
+const (
+	// AnswerPublic publishes the question and answer to everyone who can see
+	// the product, without saying who asked.
+	AnswerPublic AnswerVisibility = "public"
+	// AnswerPrivate only shows the answer to the seller who asked.
+	AnswerPrivate AnswerVisibility = "private"
+)
+
+// Question is a clarification question a seller asked about a product,
+// together with the buyer's answer once there is one.
+type Question struct {
+	ID         uint             `json:"id" gorm:"primary_key"`
+	ProductID  uint             `json:"product_id" gorm:"index"`
+	AskerID    uint             `json:"asker_id,omitempty"`
+	Body       string           `json:"body"`
+	Answer     string           `json:"answer,omitempty"`
+	Visibility AnswerVisibility `json:"visibility,omitempty"`
+	AnsweredAt *time.Time       `json:"answered_at,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+type questionRequest struct {
+	Body string `json:"body" binding:"required"`
+}
+
+type answerRequest struct {
+	Answer  string `json:"answer" binding:"required"`
+	Publish bool   `json:"publish"`
+}
+
+// extendForAnswer makes sure bidders have at least AnswerExtensionMinutes
+// left after an answer is published. The reveal phase of a commit-reveal
+// auction moves along with the close.
+func extendForAnswer(tx *gorm.DB, product *Product, at time.Time) error {
+	if product.ClosesAt == nil || product.AnswerExtensionMinutes <= 0 || product.Status != Open {
+		return nil
+	}
+
+	closesAt := at.Add(time.Duration(product.AnswerExtensionMinutes) * time.Minute)
+	if !closesAt.After(*product.ClosesAt) {
+		return nil
+	}
+
+	updates := map[string]interface{}{"closes_at": closesAt}
+	if product.RevealClosesAt != nil {
+		revealClosesAt := product.RevealClosesAt.Add(closesAt.Sub(*product.ClosesAt))
+		updates["reveal_closes_at"] = revealClosesAt
+		product.RevealClosesAt = &revealClosesAt
+	}
+
+	err := tx.Model(&Product{}).Where("id = ? AND status = ?", product.ID, Open).Updates(updates).Error
+	if err == nil {
+		product.ClosesAt = &closesAt
+	}
+	return err
+}
+
+// @Summary Ask a question about a product
+// @Description Ask the requester a clarification question about an open product. Any seller who can see the product can ask.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body questionRequest true "Question"
+// @Security ApiKeyAuth
+// @Success 201 {object} Question
+// @Router /products/{id}/questions [post]
+func askQuestion(c *gin.Context) {
+	productID := c.Param("id")
+
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input questionRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	if product.UserID == sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if product.Status != Open {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Questions cannot be asked on a %s product", product.Status)})
+		return
+	}
+
+	question := Question{ProductID: product.ID, AskerID: sellerID, Body: input.Body, CreatedAt: clock.Now()}
+	if err := db.Create(&question).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save question"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, question)
+}
+
+// @Summary Answer a question
+// @Description Answer a question about one of your products. A published answer is shown to everyone who can see the product with the asker left out, and gives bidders at least the product's answer_extension_minutes before the close. Otherwise only the asker sees it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Question ID"
+// @Param input body answerRequest true "Answer"
+// @Security ApiKeyAuth
+// @Success 200 {object} Question
+// @Failure 409 {object} map[string]interface{}
+// @Router /questions/{id}/answer [post]
+func answerQuestion(c *gin.Context) {
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input answerRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var question Question
+	if err := db.Where("id = ?", c.Param("id")).First(&question).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Question not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", question.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	visibility := AnswerPrivate
+	if input.Publish {
+		visibility = AnswerPublic
+	}
+
+	now := clock.Now()
+	tx := db.Begin()
+	result := tx.Model(&Question{}).
+		Where("id = ? AND answered_at IS NULL", question.ID).
+		Updates(map[string]interface{}{"answer": input.Answer, "visibility": visibility, "answered_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": "Question has already been answered"})
+		return
+	}
+	question.Answer = input.Answer
+	question.Visibility = visibility
+	question.AnsweredAt = &now
+
+	err = notify(tx, question.AskerID, NotifyAnswer, product.ID, nil,
+		fmt.Sprintf("Your question about %q has been answered", product.Title))
+	if err == nil && visibility == AnswerPublic {
+		err = extendForAnswer(tx, &product, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save answer"})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, question)
+}
+
+// @Summary Get the questions about a product
+// @Description Get the Q&A of a product. The requester and admins see every question with its asker; everyone else sees published answers without the asker, and their own questions.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Success 200 {array} Question
+// @Router /products/{id}/questions [get]
+func getQuestions(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	var questions []Question
+	db.Where("product_id = ?", product.ID).Order("id").Find(&questions)
+
+	viewer, _ := viewerFromContext(c)
+	if viewer != nil && (viewer.IsAdmin || viewer.UserID == product.UserID) {
+		c.JSON(http.StatusOK, questions)
+		return
+	}
+
+	visible := []Question{}
+	for _, question := range questions {
+		switch {
+		case viewer != nil && question.AskerID == viewer.UserID:
+		case question.Visibility == AnswerPublic:
+			question.AskerID = 0
+		default:
+			continue
+		}
+		visible = append(visible, question)
+	}
+
+	c.JSON(http.StatusOK, visible)
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// WithdrawalRule decides whether sellers may withdraw their bids before the
+// auction closes.
+type WithdrawalRule string
+
+const (
+	// WithdrawalAllowed lets sellers withdraw pending bids while the auction
+	// is open, up to WithdrawalCutoffMinutes before it closes.
+	WithdrawalAllowed WithdrawalRule = "allowed"
+	// WithdrawalForbidden makes every bid binding once placed.
+	WithdrawalForbidden WithdrawalRule = "forbidden"
+)
+
+// BidChange says what produced a version of a bid.
+type BidChange string
+
+const (
+	ChangePlaced     BidChange = "placed"
+	ChangeRevised    BidChange = "revised"
+	ChangeWithdrawn  BidChange = "withdrawn"
+	ChangeNegotiated BidChange = "negotiated"
+	ChangeExtended   BidChange = "extended"
+)
+
+// BidVersion is an immutable snapshot of a bid. Every change to a bid adds a
+// version; versions are never updated or deleted.
+type BidVersion struct {
+	ID              uint              `json:"id" gorm:"primary_key"`
+	BidID           uint              `json:"bid_id" gorm:"unique_index:idx_bid_version"`
+	Version         int               `json:"version" gorm:"unique_index:idx_bid_version"`
+	Change          BidChange         `json:"change"`
+	Price           float64           `json:"price"`
+	Description     string            `json:"description"`
+	QuantityOffered float64           `json:"quantity_offered"`
+	ValidUntil      *time.Time        `json:"valid_until,omitempty"`
+	Attributes      map[string]string `json:"attributes,omitempty" gorm:"-"`
+	AttributeList   string            `json:"-"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+func (v *BidVersion) BeforeSave() error {
+	lines := make([]string, 0, len(v.Attributes))
+	for name, value := range v.Attributes {
+		lines = append(lines, name+"="+value)
+	}
+	v.AttributeList = strings.Join(lines, "\n")
+	return nil
+}
+
+func (v *BidVersion) AfterFind() error {
+	v.Attributes = nil
+	if v.AttributeList == "" {
+		return nil
+	}
+	v.Attributes = map[string]string{}
+	for _, line := range strings.Split(v.AttributeList, "\n") {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			v.Attributes[parts[0]] = parts[1]
+		}
+	}
+	return nil
+}
+
+// recordBidVersion stores the current state of bid as its version
+// bid.Version.
+func recordBidVersion(tx *gorm.DB, bid *Bid, change BidChange, now time.Time) error {
+	version := BidVersion{
+		BidID:           bid.ID,
+		Version:         bid.Version,
+		Change:          change,
+		Price:           bid.Price,
+		Description:     bid.Description,
+		QuantityOffered: bid.QuantityOffered,
+		ValidUntil:      bid.ValidUntil,
+		CreatedAt:       now,
+	}
+	if len(bid.Attributes) > 0 {
+		version.Attributes = map[string]string{}
+		for _, value := range bid.Attributes {
+			version.Attributes[value.Name] = value.Value
+		}
+	}
+	return tx.Create(&version).Error
+}
+
+// bumpBidVersion applies updates to a bid and moves it to its next version.
+// The update is conditional on the version and outcome the bid had when it
+// was loaded, so concurrent changes cannot both succeed.
+func bumpBidVersion(tx *gorm.DB, bid *Bid, updates map[string]interface{}) error {
+	updates["version"] = bid.Version + 1
+
+	result := tx.Model(&Bid{}).
+		Where("id = ? AND version = ? AND outcome = ?", bid.ID, bid.Version, BidPending).
+		Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("offer %d was changed in the meantime", bid.ID)
+	}
+
+	bid.Version++
+	return nil
+}
+
+// checkChangeable refuses changes to bids that are decided or whose auction
+// no longer takes them.
+func checkChangeable(product *Product, bid *Bid, now time.Time) error {
+	if product.Status != Open {
+		return &bidError{Code: "not_open", Message: "Product is not open for offers"}
+	}
+	if !product.acceptsOffersAt(now) {
+		return &bidError{Code: "outside_window", Message: "Product is outside its bidding window"}
+	}
+	if bid.Outcome != BidPending || bid.IsDiscarded || bid.Disqualified {
+		return &bidError{Code: "offer_decided", Message: fmt.Sprintf("Offer is already %s", bid.Outcome)}
+	}
+	return nil
+}
+
+// checkWithdrawal applies the withdrawal rule of the product at now.
+func checkWithdrawal(product *Product, now time.Time) error {
+	if product.WithdrawalRule == WithdrawalForbidden {
+		return &bidError{Code: "withdrawal_not_allowed", Message: "Offers on this product cannot be withdrawn"}
+	}
+	if product.ClosesAt != nil && product.WithdrawalCutoffMinutes > 0 {
+		cutoff := product.ClosesAt.Add(-time.Duration(product.WithdrawalCutoffMinutes) * time.Minute)
+		if !now.Before(cutoff) {
+			return &bidError{Code: "withdrawal_closed", Message: "Offers can no longer be withdrawn"}
+		}
+	}
+	return nil
+}
+
+// checkWithdrawalSettings validates the withdrawal rule of a new product.
+func checkWithdrawalSettings(product *Product) error {
+	switch product.WithdrawalRule {
+	case "":
+		product.WithdrawalRule = WithdrawalAllowed
+	case WithdrawalAllowed, WithdrawalForbidden:
+	default:
+		return fmt.Errorf("unknown withdrawal rule %q", product.WithdrawalRule)
+	}
+	if product.WithdrawalCutoffMinutes < 0 {
+		return fmt.Errorf("withdrawal_cutoff_minutes must not be negative")
+	}
+	return nil
+}
+
+type revisionRequest struct {
+	Price           float64             `json:"price" binding:"required"`
+	Description     string              `json:"description"`
+	QuantityOffered float64             `json:"quantity_offered"`
+	Attributes      []BidAttributeValue `json:"attributes"`
+	ValidUntil      *time.Time          `json:"valid_until"`
+}
+
+// sellerOffer loads an offer with its product for a change by its seller. It
+// writes the error response itself.
+func sellerOffer(c *gin.Context) (*Bid, *Product, bool) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return nil, nil, false
+	}
+
+	var offer Bid
+	if err := db.Preload("Attributes").Where("id = ?", c.Param("id")).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return nil, nil, false
+	}
+	if offer.SellerID != sellerID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return nil, nil, false
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return nil, nil, false
+	}
+	return &offer, &product, true
+}
+
+// @Summary Revise an offer
+// @Description Change the price, description, quantity, validity or attribute values of an open offer. The offer keeps its ID and every previous version stays in its history. An omitted description, quantity, validity or attribute list keeps the current one.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Param input body revisionRequest true "Revised offer"
+// @Security ApiKeyAuth
+// @Success 200 {object} Bid
+// @Failure 400 {object} map[string]interface{}
+// @Router /offers/{id}/revise [post]
+func reviseOffer(c *gin.Context) {
+	offer, product, ok := sellerOffer(c)
+	if !ok {
+		return
+	}
+
+	var input revisionRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := clock.Now()
+	if err := checkChangeable(product, offer, now); err != nil {
+		respondBidError(c, err)
+		return
+	}
+	if err := checkInvited(db, product, offer.SellerID); err != nil {
+		respondBidError(c, err)
+		return
+	}
+	if product.AuctionType == CommitRevealAuction || product.announcesPrice() || product.BafoRound {
+		respondBidError(c, &bidError{Code: "revision_not_allowed", Message: "Offers cannot be revised in this auction"})
+		return
+	}
+
+	revised := *offer
+	revised.Price = input.Price
+	if input.QuantityOffered != 0 {
+		revised.QuantityOffered = input.QuantityOffered
+	}
+	if input.Description != "" {
+		revised.Description = input.Description
+	}
+	if input.Attributes != nil {
+		revised.Attributes = input.Attributes
+	}
+	if input.ValidUntil != nil {
+		revised.ValidUntil = input.ValidUntil
+	}
+	if err := checkValidity(&revised, now); err != nil {
+		respondBidError(c, err)
+		return
+	}
+
+	// A revision is held to the rules of a new bid, except that keeping the
+	// price does not have to beat the best bid again
+	tx := db.Begin()
+	var lot *Lot
+	var err error
+	if revised.isBundle() {
+		err = checkBundle(tx, product, &revised)
+	} else {
+		lot, err = bidLot(tx, product, &revised)
+		if err == nil && revised.Price != offer.Price {
+			err = checkOfferPrice(tx, product, lot, revised.Price, now)
+		}
+	}
+	if err == nil {
+		err = checkOfferTerms(tx, product, lot, &revised)
+	}
+	if err == nil {
+		err = bumpBidVersion(tx, &revised, map[string]interface{}{
+			"price":            revised.Price,
+			"description":      revised.Description,
+			"quantity_offered": revised.QuantityOffered,
+			"valid_until":      revised.ValidUntil,
+		})
+	}
+	if err == nil && input.Attributes != nil {
+		err = tx.Where("bid_id = ?", revised.ID).Delete(&BidAttributeValue{}).Error
+		for i := range revised.Attributes {
+			if err != nil {
+				break
+			}
+			revised.Attributes[i].BidID = revised.ID
+			err = tx.Create(&revised.Attributes[i]).Error
+		}
+	}
+	if err == nil {
+		err = recordBidVersion(tx, &revised, ChangeRevised, now)
+	}
+	if err == nil {
+		err = extendForLateBid(tx, product, now)
+	}
+	if err == nil {
+		err = runProxyAgents(tx, product, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		if _, ok := err.(*bidError); ok {
+			respondBidError(c, err)
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	visible, err := visibleBids(db, product, &Token{UserID: revised.SellerID}, []Bid{revised})
+	if err == nil && len(visible) == 1 {
+		revised = visible[0]
+	}
+
+	c.JSON(http.StatusOK, revised)
+}
+
+// @Summary Withdraw an offer
+// @Description Withdraw an open offer before the auction closes, when the withdrawal rule of the product allows it. The offer stays in the history but can no longer win.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {object} Bid
+// @Failure 400 {object} map[string]interface{}
+// @Router /offers/{id}/withdraw [post]
+func withdrawOffer(c *gin.Context) {
+	offer, product, ok := sellerOffer(c)
+	if !ok {
+		return
+	}
+
+	now := clock.Now()
+	if err := checkChangeable(product, offer, now); err != nil {
+		respondBidError(c, err)
+		return
+	}
+	if err := checkInvited(db, product, offer.SellerID); err != nil {
+		respondBidError(c, err)
+		return
+	}
+	if err := checkWithdrawal(product, now); err != nil {
+		respondBidError(c, err)
+		return
+	}
+
+	tx := db.Begin()
+	err := bumpBidVersion(tx, offer, map[string]interface{}{"outcome": BidWithdrawn, "withdrawn_at": now})
+	if err == nil {
+		offer.Outcome = BidWithdrawn
+		offer.WithdrawnAt = &now
+		err = recordBidVersion(tx, offer, ChangeWithdrawn, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, offer)
+}
+
+// @Summary Get the version history of an offer
+// @Description Get every version of an offer, oldest first, with the time and kind of each change. The history is visible to whoever may see the product and the offer itself.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} BidVersion
+// @Router /offers/{id}/versions [get]
+func getOfferVersions(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var offer Bid
+	if err := db.Where("id = ?", c.Param("id")).First(&offer).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+		return
+	}
+
+	var product Product
+	if err := db.Where("id = ?", offer.ProductID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	visible, err := visibleBids(db, &product, viewer, []Bid{offer})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offer"})
+		return
+	}
+	if len(visible) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	var versions []BidVersion
+	db.Where("bid_id = ?", offer.ID).Order("version").Find(&versions)
+
+	c.JSON(http.StatusOK, versions)
+}
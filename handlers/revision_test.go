@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReviseOfferKeepsOmittedQuantity(t *testing.T) {
+	setupTestDB(t)
+
+	product := Product{Title: "Bolts", UserID: 1, Status: Open, Quantity: 100, AuctionType: OpenAuction}
+	mustCreate(t, &product)
+	offer := Bid{ProductID: product.ID, SellerID: 2, Price: 10, QuantityOffered: 40, Version: 1}
+	mustCreate(t, &offer)
+
+	w := call(reviseOffer, http.MethodPost, idParam(offer.ID), revisionRequest{Price: 9}, &Token{UserID: 2})
+	expectStatus(t, w, http.StatusOK)
+
+	var revised Bid
+	db.Where("id = ?", offer.ID).First(&revised)
+	if revised.Price != 9 || revised.QuantityOffered != 40 {
+		t.Errorf("revised offer has price %g and quantity %g, want 9 and 40", revised.Price, revised.QuantityOffered)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// scheduler periodically closes auctions whose deadline has passed. All of its
+// state lives in the database, so overdue auctions are picked up by the first
+// tick after a restart.
+type scheduler struct {
+	db       *gorm.DB
+	clock    Clock
+	interval time.Duration
+}
+
+func newScheduler(db *gorm.DB, clock Clock, interval time.Duration) *scheduler {
+	return &scheduler{db: db, clock: clock, interval: interval}
+}
+
+// run ticks once immediately and then every interval until stop is closed.
+func (s *scheduler) run(stop <-chan struct{}) {
+	s.tick()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tick moves the price clocks of Dutch auctions and the rounds of Japanese
+// auctions, expires counter-offers, sends alert digests, closes every open
+// auction whose closing time has been reached and settles commit-reveal
+// auctions whose reveal phase is over.
+func (s *scheduler) tick() {
+	now := s.clock.Now()
+
+	s.advanceDutchClocks(now)
+	s.advanceJapaneseRounds(now)
+	s.expireCounterOffers(now)
+	s.sendAlertDigests(now)
+
+	// Deadlines are compared in Go rather than in SQL because SQLite stores
+	// timestamps as text and would compare different offsets incorrectly
+	var open []Product
+	if err := s.db.Where("status = ? AND closes_at IS NOT NULL", Open).Find(&open).Error; err != nil {
+		log.Println("scheduler: failed to load open auctions:", err)
+		return
+	}
+
+	for i := range open {
+		if open[i].ClosesAt.After(now) {
+			continue
+		}
+		if err := s.closeAuction(&open[i], now); err != nil {
+			log.Printf("scheduler: failed to close product %d: %v", open[i].ID, err)
+		}
+	}
+
+	var revealing []Product
+	err := s.db.Where("status = ? AND auction_type = ? AND reveal_closes_at IS NOT NULL AND reveals_settled = ?",
+		Closed, CommitRevealAuction, false).
+		Find(&revealing).Error
+	if err != nil {
+		log.Println("scheduler: failed to load commit-reveal auctions:", err)
+		return
+	}
+
+	for i := range revealing {
+		if revealing[i].RevealClosesAt.After(now) {
+			continue
+		}
+		if err := s.settleReveals(&revealing[i], now); err != nil {
+			log.Printf("scheduler: failed to settle reveals of product %d: %v", revealing[i].ID, err)
+		}
+	}
+}
+
+// closeAuction closes a single product and applies its award policy.
+// Commit-reveal auctions are only closed here; they are settled once their
+// reveal phase is over.
+func (s *scheduler) closeAuction(product *Product, now time.Time) error {
+	tx := s.db.Begin()
+
+	if err := transitionProduct(tx, product, Closed, 0); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if product.AuctionType != CommitRevealAuction {
+		if err := applyAwardPolicy(tx, product, now); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// settleReveals disqualifies the unrevealed bids of a commit-reveal auction
+// whose reveal phase is over and applies its award policy. The product is
+// marked settled, so a product left closed under the manual award policy is
+// not settled again on every tick.
+func (s *scheduler) settleReveals(product *Product, now time.Time) error {
+	tx := s.db.Begin()
+
+	result := tx.Model(&Product{}).
+		Where("id = ? AND reveals_settled = ?", product.ID, false).
+		Update("reveals_settled", true)
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil
+	}
+	product.RevealsSettled = true
+
+	if err := disqualifyUnrevealed(tx, product); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := applyAwardPolicy(tx, product, now); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
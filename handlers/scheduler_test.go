@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func loadProduct(t *testing.T, id uint) Product {
+	t.Helper()
+	var product Product
+	if err := db.Where("id = ?", id).First(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+func loadBid(t *testing.T, id uint) Bid {
+	t.Helper()
+	var bid Bid
+	if err := db.Where("id = ?", id).First(&bid).Error; err != nil {
+		t.Fatal(err)
+	}
+	return bid
+}
+
+func TestSchedulerClosesAtClosesAt(t *testing.T) {
+	fake := setupTestDB(t)
+	closesAt := fake.Now().Add(10 * time.Minute)
+	product := Product{Title: "Paint", UserID: 1, Status: Open, ClosesAt: &closesAt, AwardPolicy: AwardManual}
+	mustCreate(t, &product)
+	mustCreate(t, &Bid{ProductID: product.ID, SellerID: 2, Price: 10})
+
+	s := newScheduler(db, fake, time.Second)
+
+	fake.Advance(10*time.Minute - time.Second)
+	s.tick()
+	if got := loadProduct(t, product.ID).Status; got != Open {
+		t.Fatalf("status before closes_at = %s, want open", got)
+	}
+
+	fake.Advance(time.Second)
+	s.tick()
+	if got := loadProduct(t, product.ID).Status; got != Closed {
+		t.Fatalf("status at closes_at = %s, want closed", got)
+	}
+
+	var transition ProductTransition
+	db.Where("product_id = ?", product.ID).Last(&transition)
+	if transition.To != Closed || transition.ActorID != 0 {
+		t.Errorf("last transition = %+v, want a system close", transition)
+	}
+}
+
+func TestSchedulerAppliesAwardPolicy(t *testing.T) {
+	tests := []struct {
+		policy AwardPolicy
+		status Status
+		winner bool
+	}{
+		{AwardManual, Closed, false},
+		{AwardLowestPrice, Awarded, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			fake := setupTestDB(t)
+			closesAt := fake.Now().Add(time.Minute)
+			product := Product{Title: "Gravel", UserID: 1, Status: Open, ClosesAt: &closesAt, AwardPolicy: tt.policy}
+			mustCreate(t, &product)
+			high := Bid{ProductID: product.ID, SellerID: 2, Price: 12}
+			low := Bid{ProductID: product.ID, SellerID: 3, Price: 9}
+			mustCreate(t, &high)
+			mustCreate(t, &low)
+
+			fake.Advance(time.Minute)
+			newScheduler(db, fake, time.Second).tick()
+
+			if got := loadProduct(t, product.ID).Status; got != tt.status {
+				t.Errorf("status = %s, want %s", got, tt.status)
+			}
+			want := map[uint]BidOutcome{high.ID: BidPending, low.ID: BidPending}
+			if tt.winner {
+				want = map[uint]BidOutcome{high.ID: BidLost, low.ID: BidWon}
+			}
+			for id, outcome := range want {
+				if got := loadBid(t, id).Outcome; got != outcome {
+					t.Errorf("bid %d outcome = %s, want %s", id, got, outcome)
+				}
+			}
+
+			var award Award
+			found := db.Where("product_id = ?", product.ID).First(&award).Error == nil
+			if found != tt.winner {
+				t.Errorf("award recorded = %v, want %v", found, tt.winner)
+			}
+			if found && !award.CreatedAt.Equal(fake.Now()) {
+				t.Errorf("award created at %s, want the fake clock's %s", award.CreatedAt, fake.Now())
+			}
+		})
+	}
+}
+
+func TestSchedulerExpiresWithoutValidBids(t *testing.T) {
+	fake := setupTestDB(t)
+	closesAt := fake.Now().Add(time.Minute)
+	product := Product{Title: "Sand", UserID: 1, Status: Open, ClosesAt: &closesAt, AwardPolicy: AwardLowestPrice}
+	mustCreate(t, &product)
+
+	// Neither a discarded bid nor one past its validity can win
+	validUntil := fake.Now().Add(30 * time.Second)
+	mustCreate(t, &Bid{ProductID: product.ID, SellerID: 2, Price: 10, IsDiscarded: true})
+	mustCreate(t, &Bid{ProductID: product.ID, SellerID: 3, Price: 11, ValidUntil: &validUntil})
+
+	fake.Advance(time.Minute)
+	newScheduler(db, fake, time.Second).tick()
+
+	if got := loadProduct(t, product.ID).Status; got != Expired {
+		t.Errorf("status = %s, want expired", got)
+	}
+}
+
+func TestSchedulerPicksUpOverdueAuctionsOnStart(t *testing.T) {
+	fake := setupTestDB(t)
+
+	// Auctions that were due while the server was down
+	var ids []uint
+	for i := 1; i <= 3; i++ {
+		closesAt := fake.Now().Add(-time.Duration(i) * time.Hour)
+		product := Product{Title: "Overdue", UserID: 1, Status: Open, ClosesAt: &closesAt, AwardPolicy: AwardLowestPrice}
+		mustCreate(t, &product)
+		mustCreate(t, &Bid{ProductID: product.ID, SellerID: 2, Price: 10})
+		ids = append(ids, product.ID)
+	}
+	future := fake.Now().Add(time.Hour)
+	pending := Product{Title: "Running", UserID: 1, Status: Open, ClosesAt: &future}
+	mustCreate(t, &pending)
+
+	stop := make(chan struct{})
+	close(stop)
+	newScheduler(db, fake, time.Hour).run(stop)
+
+	for _, id := range ids {
+		if got := loadProduct(t, id).Status; got != Awarded {
+			t.Errorf("overdue product %d is %s, want awarded", id, got)
+		}
+	}
+	if got := loadProduct(t, pending.ID).Status; got != Open {
+		t.Errorf("running product is %s, want open", got)
+	}
+}
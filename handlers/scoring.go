@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AttributeType tells how the values of a scoring attribute are compared.
+type AttributeType string
+
+const (
+	LowerIsBetter  AttributeType = "lower_is_better"
+	HigherIsBetter AttributeType = "higher_is_better"
+	BooleanAttr    AttributeType = "boolean"
+	EnumAttr       AttributeType = "enum"
+)
+
+// ScoringAttribute is one weighted criterion of a product's scoring model,
+// such as delivery time or warranty. Enum options are listed best first.
+type ScoringAttribute struct {
+	ID         uint          `json:"id" gorm:"primary_key"`
+	ProductID  uint          `json:"product_id" gorm:"index"`
+	Name       string        `json:"name"`
+	Type       AttributeType `json:"type"`
+	Weight     float64       `json:"weight"`
+	Options    []string      `json:"options,omitempty" gorm:"-"`
+	OptionList string        `json:"-"`
+}
+
+func (a *ScoringAttribute) BeforeSave() error {
+	a.OptionList = strings.Join(a.Options, "\n")
+	return nil
+}
+
+func (a *ScoringAttribute) AfterFind() error {
+	a.Options = nil
+	if a.OptionList != "" {
+		a.Options = strings.Split(a.OptionList, "\n")
+	}
+	return nil
+}
+
+// BidAttributeValue is the value a seller offers for a scoring attribute.
+type BidAttributeValue struct {
+	ID    uint   `json:"id" gorm:"primary_key"`
+	BidID uint   `json:"bid_id" gorm:"index"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// checkScoringModel validates the scoring model of a new product request.
+func checkScoringModel(product *Product) error {
+	if product.PriceWeight < 0 {
+		return fmt.Errorf("price_weight must not be negative")
+	}
+	if len(product.Attributes) == 0 && product.PriceWeight == 0 {
+		product.PriceWeight = 1
+	}
+
+	seen := map[string]bool{}
+	for i := range product.Attributes {
+		attr := &product.Attributes[i]
+		attr.ID = 0
+		if attr.Name == "" {
+			return fmt.Errorf("scoring attributes need a name")
+		}
+		if seen[attr.Name] {
+			return fmt.Errorf("scoring attribute %q is defined twice", attr.Name)
+		}
+		seen[attr.Name] = true
+		if attr.Weight <= 0 {
+			return fmt.Errorf("scoring attribute %q needs a positive weight", attr.Name)
+		}
+
+		switch attr.Type {
+		case LowerIsBetter, HigherIsBetter, BooleanAttr:
+			attr.Options = nil
+		case EnumAttr:
+			if len(attr.Options) == 0 {
+				return fmt.Errorf("enum attribute %q needs options", attr.Name)
+			}
+		default:
+			return fmt.Errorf("scoring attribute %q has unknown type %q", attr.Name, attr.Type)
+		}
+	}
+	return nil
+}
+
+// productAttributes loads the scoring model of a product.
+func productAttributes(tx *gorm.DB, productID uint) ([]ScoringAttribute, error) {
+	var attrs []ScoringAttribute
+	err := tx.Where("product_id = ?", productID).Order("id").Find(&attrs).Error
+	return attrs, err
+}
+
+// checkBidAttributes makes sure the offer supplies a valid value for every
+// attribute of the scoring model and nothing else.
+func checkBidAttributes(attrs []ScoringAttribute, offer *Bid) error {
+	values := map[string]string{}
+	for i := range offer.Attributes {
+		offer.Attributes[i].ID = 0
+		values[offer.Attributes[i].Name] = offer.Attributes[i].Value
+	}
+	if len(values) != len(offer.Attributes) {
+		return fmt.Errorf("attributes must not be repeated")
+	}
+
+	for _, attr := range attrs {
+		value, ok := values[attr.Name]
+		if !ok {
+			return fmt.Errorf("attribute %q is required", attr.Name)
+		}
+		if _, err := attributeScore(attr, value); err != nil {
+			return err
+		}
+		delete(values, attr.Name)
+	}
+	for name := range values {
+		return fmt.Errorf("unknown attribute %q", name)
+	}
+	return nil
+}
+
+// attributeScore turns a value into a number where higher is better. Numeric
+// values are returned as they are and normalized later across all bids;
+// booleans and enums are already in [0, 1].
+func attributeScore(attr ScoringAttribute, value string) (float64, error) {
+	switch attr.Type {
+	case LowerIsBetter, HigherIsBetter:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return 0, fmt.Errorf("attribute %q must be a number", attr.Name)
+		}
+		return number, nil
+	case BooleanAttr:
+		flag, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, fmt.Errorf("attribute %q must be true or false", attr.Name)
+		}
+		if flag {
+			return 1, nil
+		}
+		return 0, nil
+	case EnumAttr:
+		for i, option := range attr.Options {
+			if option == value {
+				if len(attr.Options) == 1 {
+					return 1, nil
+				}
+				return 1 - float64(i)/float64(len(attr.Options)-1), nil
+			}
+		}
+		return 0, fmt.Errorf("attribute %q must be one of %s", attr.Name, strings.Join(attr.Options, ", "))
+	}
+	return 0, fmt.Errorf("attribute %q has unknown type %q", attr.Name, attr.Type)
+}
+
+// scoreBids sets the Score of every bid to a value between 0 and 1, higher
+// being better. Price and numeric attributes are min-max normalized across the
+// given bids, so scores are only comparable within one call. Bids must have
+// their Attributes loaded.
+func scoreBids(product *Product, attrs []ScoringAttribute, bids []Bid) {
+	if len(bids) == 0 {
+		return
+	}
+
+	totalWeight := product.PriceWeight
+	for _, attr := range attrs {
+		totalWeight += attr.Weight
+	}
+	if totalWeight == 0 {
+		return
+	}
+
+	prices := make([]float64, len(bids))
+	for i := range bids {
+		prices[i] = bids[i].Price
+	}
+	priceScores := normalize(prices, false)
+
+	scores := make([]float64, len(bids))
+	for i := range bids {
+		scores[i] = product.PriceWeight * priceScores[i]
+	}
+
+	for _, attr := range attrs {
+		raw := make([]float64, len(bids))
+		for i := range bids {
+			raw[i], _ = attributeScore(attr, bids[i].attributeValue(attr.Name))
+		}
+		if attr.Type == LowerIsBetter || attr.Type == HigherIsBetter {
+			raw = normalize(raw, attr.Type == HigherIsBetter)
+		}
+		for i := range bids {
+			scores[i] += attr.Weight * raw[i]
+		}
+	}
+
+	for i := range bids {
+		bids[i].Score = scores[i] / totalWeight
+	}
+}
+
+// normalize maps values onto [0, 1] with 1 for the best value. When all values
+// are equal every value scores 1.
+func normalize(values []float64, higherIsBetter bool) []float64 {
+	low, high := values[0], values[0]
+	for _, v := range values {
+		low = math.Min(low, v)
+		high = math.Max(high, v)
+	}
+
+	normalized := make([]float64, len(values))
+	for i, v := range values {
+		switch {
+		case high == low:
+			normalized[i] = 1
+		case higherIsBetter:
+			normalized[i] = (v - low) / (high - low)
+		default:
+			normalized[i] = (high - v) / (high - low)
+		}
+	}
+	return normalized
+}
+
+func (b *Bid) attributeValue(name string) string {
+	for _, attr := range b.Attributes {
+		if attr.Name == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// sortByScore orders scored bids best first. Ties go to the earlier bid.
+func sortByScore(bids []Bid) {
+	sort.SliceStable(bids, func(i, j int) bool {
+		if bids[i].Score != bids[j].Score {
+			return bids[i].Score > bids[j].Score
+		}
+		return bids[i].ID < bids[j].ID
+	})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"log"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -11,6 +12,15 @@ import (
 	_ "uniproject/docs"
 )
 
+// models are the tables AutoMigrate keeps up to date.
+var models = []interface{}{&User{}, &Product{}, &Bid{}, &ProductTransition{}, &ProxyAgent{},
+	&ScoringAttribute{}, &BidAttributeValue{}, &Award{}, &Lot{}, &AwardProposal{},
+	&AuctionRound{}, &RoundParticipant{}, &RoundResponse{}, &ShortlistEntry{},
+	&CounterOffer{}, &BidVersion{}, &Notification{}, &Invitation{},
+	&Question{}, &Conversation{}, &Message{}, &MessageAttachment{},
+	&Blob{}, &Attachment{}, &Category{}, &SellerCategory{},
+	&Subscription{}, &SavedSearch{}, &AlertSettings{}, &Alert{}}
+
 // @title Reverse Auction API
 // @version 1.0
 // @description API for the reverse auction project
@@ -23,8 +33,28 @@ func Run() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	// Databases from before the product lifecycle have no transition table
+	legacy := db.HasTable(&Product{}) && !db.HasTable(&ProductTransition{})
+
 	// AutoMigrate will attempt to automatically migrate the schema
-	db.AutoMigrate(&User{}, &Product{}, &Bid{})
+	db.AutoMigrate(models...)
+	if legacy {
+		if err := migrateLegacyProducts(db); err != nil {
+			log.Fatal("Failed to migrate products:", err)
+		}
+	}
+
+	// Invitations by seller ID used to store an empty token, which the
+	// unique index only allows once
+	db.Model(&Invitation{}).Where("token = ?", "").Update("token", nil)
+
+	// Attachments go to the local filesystem or an S3-compatible store
+	blobs = newBlobStore()
+	alertChannels = newAlertChannels()
+
+	// Close auctions whose deadline has passed, including those that expired
+	// while the server was down
+	go newScheduler(db, clock, 15*time.Second).run(nil)
 
 	// Set up the HTTP router
 	router := gin.Default()
@@ -43,6 +73,19 @@ func Run() {
 	profileGroup := router.Group("/profile")
 	profileGroup.Use(authMiddleware)
 	profileGroup.GET("", userProfile)
+	profileGroup.GET("/notifications", getNotifications)
+	profileGroup.POST("/notifications/:id/read", readNotification)
+	profileGroup.GET("/categories", getServedCategories)
+	profileGroup.PUT("/categories", setServedCategories)
+	profileGroup.GET("/subscriptions", getSubscriptions)
+	profileGroup.POST("/subscriptions", subscribe)
+	profileGroup.DELETE("/subscriptions/:id", unsubscribe)
+	profileGroup.GET("/searches", getSavedSearches)
+	profileGroup.POST("/searches", saveSearch)
+	profileGroup.DELETE("/searches/:id", deleteSavedSearch)
+	profileGroup.GET("/searches/:id/products", runSavedSearch)
+	profileGroup.GET("/alert-settings", getAlertSettings)
+	profileGroup.PUT("/alert-settings", setAlertSettings)
 
 	productAuthGroup := apiGroup.Group("")
 	productAuthGroup.Use(authMiddleware)
@@ -51,14 +94,70 @@ func Run() {
 	productAuthGroup.POST("/products/:id/approve", approveProductRequest)
 	productAuthGroup.POST("/products/:id/offers", makeOffer)
 	productAuthGroup.GET("/products/:id/offers", getOffers)
+	productAuthGroup.POST("/products/:id/submit", submitProduct)
+	productAuthGroup.POST("/products/:id/close", closeProduct)
+	productAuthGroup.POST("/products/:id/cancel", cancelProduct)
+	productAuthGroup.POST("/products/:id/expire", expireProduct)
+	productAuthGroup.GET("/products/:id/transitions", getProductTransitions)
+	productAuthGroup.POST("/products/:id/agents", registerProxyAgent)
+	productAuthGroup.GET("/products/:id/agents", getProxyAgents)
+	productAuthGroup.DELETE("/agents/:id", cancelProxyAgent)
+	productAuthGroup.GET("/products/:id/split", proposeSplit)
+	productAuthGroup.POST("/products/:id/awards", awardSplit)
+	productAuthGroup.GET("/products/:id/awards", getAwards)
+	productAuthGroup.GET("/products/:id/lots", listLots)
+	productAuthGroup.POST("/products/:id/proposals", proposeWinners)
+	productAuthGroup.POST("/products/:id/proposals/:proposal_id/confirm", confirmProposal)
+	productAuthGroup.POST("/products/:id/accept-price", acceptCurrentPrice)
+	productAuthGroup.GET("/products/:id/rounds", getRounds)
+	productAuthGroup.POST("/products/:id/rounds/current", respondToRound)
+	productAuthGroup.POST("/products/:id/shortlist", shortlistSellers)
+	productAuthGroup.GET("/products/:id/shortlist", getShortlist)
 
 	productAuthGroup.POST("/offers/:id/discard", discardOffer)
 	productAuthGroup.POST("/offers/:id/approve", approveOffer)
-	productAuthGroup.POST("/offers/:id/reject", acceptOffer)
-	productAuthGroup.POST("/offers/:id/accept", rejectOffer)
+	productAuthGroup.POST("/offers/:id/reject", rejectOffer)
+	productAuthGroup.POST("/offers/:id/accept", acceptOffer)
+	productAuthGroup.POST("/offers/:id/reveal", revealOffer)
+	productAuthGroup.POST("/offers/:id/counter", counterOffer)
+	productAuthGroup.POST("/offers/:id/counter/accept", acceptCounterOffer)
+	productAuthGroup.POST("/offers/:id/counter/decline", declineCounterOffer)
+	productAuthGroup.GET("/offers/:id/negotiation", getNegotiation)
+	productAuthGroup.POST("/offers/:id/revise", reviseOffer)
+	productAuthGroup.POST("/offers/:id/withdraw", withdrawOffer)
+	productAuthGroup.GET("/offers/:id/versions", getOfferVersions)
+	productAuthGroup.POST("/offers/:id/validity", extendOfferValidity)
+	productAuthGroup.POST("/products/:id/validity-requests", requestValidityExtension)
+	productAuthGroup.POST("/products/:id/invitations", inviteSellers)
+	productAuthGroup.GET("/products/:id/invitations", getInvitations)
+	productAuthGroup.GET("/invitations", getMyInvitations)
+	productAuthGroup.POST("/invitations/:id/accept", acceptInvitation)
+	productAuthGroup.POST("/invitations/:id/decline", declineInvitation)
+	productAuthGroup.POST("/invitations/:id/revoke", revokeInvitation)
+	productAuthGroup.POST("/invite-links/:token/accept", redeemInviteLink)
+	productAuthGroup.POST("/products/:id/questions", askQuestion)
+	productAuthGroup.POST("/questions/:id/answer", answerQuestion)
+	productAuthGroup.POST("/products/:id/conversations", startConversation)
+	productAuthGroup.GET("/conversations", getConversations)
+	productAuthGroup.POST("/conversations/:id/messages", sendMessage)
+	productAuthGroup.GET("/conversations/:id/messages", getMessages)
+	productAuthGroup.POST("/conversations/:id/read", readConversation)
+	productAuthGroup.GET("/conversations/:id/attachments/:attachment_id", downloadMessageAttachment)
+	productAuthGroup.POST("/conversations/:id/dispute", openDispute)
+	productAuthGroup.POST("/conversations/:id/dispute/close", closeDispute)
+	productAuthGroup.POST("/products/:id/attachments", attachProductFile)
+	productAuthGroup.POST("/offers/:id/attachments", attachOfferFile)
+	productAuthGroup.GET("/offers/:id/attachments", getOfferFiles)
+	productAuthGroup.POST("/categories", createCategory)
+	productAuthGroup.PUT("/categories/:id", updateCategory)
+	productAuthGroup.DELETE("/categories/:id", deleteCategory)
 
 	productGroup := apiGroup.Group("")
-	productGroup.GET("/products", listProducts)
+	productGroup.GET("/products", optionalAuthMiddleware, listProducts)
+	productGroup.GET("/products/:id/questions", optionalAuthMiddleware, getQuestions)
+	productGroup.GET("/products/:id/attachments", optionalAuthMiddleware, getProductFiles)
+	productGroup.GET("/attachments/:id", optionalAuthMiddleware, downloadAttachment)
+	productGroup.GET("/categories", listCategories)
 
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// extendForLateBid implements the soft close of an auction. A bid received
+// within the last ExtensionWindowMinutes before the close pushes the closing
+// time out by ExtensionMinutes, at most MaxExtensions times.
+func extendForLateBid(tx *gorm.DB, product *Product, at time.Time) error {
+	if product.ClosesAt == nil || product.ExtensionWindowMinutes <= 0 || product.ExtensionMinutes <= 0 {
+		return nil
+	}
+	if product.ExtensionCount >= product.MaxExtensions {
+		return nil
+	}
+
+	window := time.Duration(product.ExtensionWindowMinutes) * time.Minute
+	if product.ClosesAt.Sub(at) > window {
+		return nil
+	}
+
+	closesAt := product.ClosesAt.Add(time.Duration(product.ExtensionMinutes) * time.Minute)
+
+	// Only one of several concurrent late bids gets to extend the deadline
+	result := tx.Model(&Product{}).
+		Where("id = ? AND extension_count = ?", product.ID, product.ExtensionCount).
+		Updates(map[string]interface{}{"closes_at": closesAt, "extension_count": product.ExtensionCount + 1})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 1 {
+		product.ClosesAt = &closesAt
+		product.ExtensionCount++
+	}
+	return nil
+}
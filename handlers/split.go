@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// splitLine is one bid of a proposed or requested split award.
+type splitLine struct {
+	BidID     uint    `json:"bid_id"`
+	SellerID  uint    `json:"seller_id,omitempty"`
+	Quantity  float64 `json:"quantity"`
+	UnitPrice float64 `json:"unit_price,omitempty"`
+	Cost      float64 `json:"cost,omitempty"`
+}
+
+// splitProposal is the cheapest way found to cover the requested quantity.
+type splitProposal struct {
+	Lines         []splitLine `json:"lines"`
+	TotalQuantity float64     `json:"total_quantity"`
+	TotalCost     float64     `json:"total_cost"`
+	Complete      bool        `json:"complete"`
+}
+
+// cheapestSplit covers quantity with the given bids at the lowest total cost.
+// Bids can be awarded partially, so taking the lowest unit prices first is
+// optimal. Complete is false when the bids do not offer enough in total.
+func cheapestSplit(quantity float64, bids []Bid) splitProposal {
+	sorted := append([]Bid(nil), bids...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Price < sorted[j].Price
+	})
+
+	proposal := splitProposal{Lines: []splitLine{}}
+	remaining := quantity
+	for _, bid := range sorted {
+		if remaining <= 0 {
+			break
+		}
+		take := bid.QuantityOffered
+		if take > remaining {
+			take = remaining
+		}
+		if take <= 0 {
+			continue
+		}
+
+		cost := take * bid.Price
+		proposal.Lines = append(proposal.Lines, splitLine{
+			BidID:     bid.ID,
+			SellerID:  bid.SellerID,
+			Quantity:  take,
+			UnitPrice: bid.Price,
+			Cost:      cost,
+		})
+		proposal.TotalQuantity += take
+		proposal.TotalCost += cost
+		remaining -= take
+	}
+	proposal.Complete = remaining <= 0
+	return proposal
+}
+
+// @Summary Propose a split award
+// @Description Propose the cheapest split of the requested quantity across the valid offers. Only the requester or an admin can ask for it.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param lot_id query int false "Split this lot instead of the whole product"
+// @Security ApiKeyAuth
+// @Success 200 {object} splitProposal
+// @Failure 400 {object} map[string]interface{}
+// @Router /products/{id}/split [get]
+func proposeSplit(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	if product.UserID != viewer.UserID && !viewer.IsAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	// Split the product itself or, for multi-lot products, one of its lots
+	quantity := product.Quantity
+	var lotID *uint
+	if lotParam := c.Query("lot_id"); lotParam != "" {
+		var lot Lot
+		if err := db.Where("id = ? AND product_id = ?", lotParam, product.ID).First(&lot).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lot not found"})
+			return
+		}
+		quantity = lot.Quantity
+		lotID = &lot.ID
+	}
+
+	if quantity == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "There is no quantity to split"})
+		return
+	}
+
+	bids, err := validBids(db, &product, lotID, clock.Now())
+	if err == nil {
+		bids, err = visibleBids(db, &product, viewer, bids)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cheapestSplit(quantity, bids))
+}
+
+// @Summary Award a product to several offers
+// @Description Award parts of the requested quantity to several offers at once. The awarded quantities must not exceed the requested quantity or what each offer offers.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body []splitLine true "Offers and quantities to award"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Failure 409 {object} map[string]interface{}
+// @Router /products/{id}/awards [post]
+func awardSplit(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input []splitLine
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+
+	now := clock.Now()
+	if product.inRevealPhase(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offers cannot be awarded during the reveal phase"})
+		return
+	}
+
+	tx := db.Begin()
+	lines := make([]awardLine, len(input))
+	for i, line := range input {
+		var bid Bid
+		if err := tx.Where("id = ? AND product_id = ?", line.BidID, product.ID).First(&bid).Error; err != nil {
+			tx.Rollback()
+			c.JSON(http.StatusNotFound, gin.H{"error": "Offer not found"})
+			return
+		}
+		lines[i] = awardLine{Bid: &bid, Quantity: line.Quantity}
+	}
+
+	if err := awardBids(tx, &product, lines, userID, now); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit().Error; err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to award offers"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get the awards of a product
+// @Description Get the offers a product was awarded to and the awarded quantities.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Award
+// @Router /products/{id}/awards [get]
+func getAwards(c *gin.Context) {
+	productID := c.Param("id")
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+	if hideProduct(c, &product) {
+		return
+	}
+
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	// Awards carry prices, so sellers only see their own outside open auctions
+	query := db.Where("product_id = ?", product.ID)
+	if product.AuctionType != OpenAuction && !viewer.IsAdmin && viewer.UserID != product.UserID {
+		query = query.Where("seller_id = ?", viewer.UserID)
+	}
+
+	var awards []Award
+	query.Order("id").Find(&awards)
+
+	c.JSON(http.StatusOK, awards)
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Subscription asks for an alert about every new product request in a
+// category (or any of its subcategories), mentioning one of the keywords and
+// with a budget in range. Criteria left empty match everything.
+type Subscription struct {
+	ID         uint      `json:"id" gorm:"primary_key"`
+	SellerID   uint      `json:"seller_id" gorm:"index"`
+	CategoryID *uint     `json:"category_id,omitempty"`
+	Keywords   string    `json:"keywords,omitempty"`
+	MinBudget  *float64  `json:"min_budget,omitempty"`
+	MaxBudget  *float64  `json:"max_budget,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// SavedSearch is a named product search a seller can run again. With Alert
+// set, new product requests matching it are announced like a subscription.
+type SavedSearch struct {
+	ID         uint      `json:"id" gorm:"primary_key"`
+	SellerID   uint      `json:"seller_id" gorm:"index"`
+	Name       string    `json:"name"`
+	Filter     string    `json:"filter,omitempty"`
+	CategoryID *uint     `json:"category_id,omitempty"`
+	MinBudget  *float64  `json:"min_budget,omitempty"`
+	MaxBudget  *float64  `json:"max_budget,omitempty"`
+	Alert      bool      `json:"alert"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// keywords splits a keyword list on commas and whitespace.
+func keywords(list string) []string {
+	return strings.FieldsFunc(strings.ToLower(list), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// mentionsAny reports whether the title or description of product contains
+// one of words. An empty list matches every product.
+func (p *Product) mentionsAny(words []string) bool {
+	if len(words) == 0 {
+		return true
+	}
+	text := strings.ToLower(p.Title + " " + p.Description)
+	for _, word := range words {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// budgetInRange reports whether the budget of product lies within min and
+// max. Products that do not state a budget match every range.
+func (p *Product) budgetInRange(min, max *float64) bool {
+	if p.MaxBudget == nil {
+		return true
+	}
+	if min != nil && *p.MaxBudget < *min {
+		return false
+	}
+	if max != nil && *p.MaxBudget > *max {
+		return false
+	}
+	return true
+}
+
+// inCategories reports whether categoryID is unset or one of ids.
+func inCategories(categoryID *uint, ids []uint) bool {
+	if categoryID == nil {
+		return true
+	}
+	for _, id := range ids {
+		if id == *categoryID {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingSellers returns the sellers to alert about product: those who serve
+// its category, subscribed to it or saved an alerting search for it. The
+// requester and sellers who may not see the product are left out.
+func matchingSellers(tx *gorm.DB, product *Product) ([]uint, error) {
+	var category Category
+	if err := tx.Where("id = ?", product.CategoryID).First(&category).Error; err != nil {
+		return nil, err
+	}
+	ancestors := category.ancestorIDs()
+
+	candidates := map[uint]bool{}
+
+	served, err := sellersServing(tx, category.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, sellerID := range served {
+		candidates[sellerID] = true
+	}
+
+	var subscriptions []Subscription
+	err = tx.Where("category_id IS NULL OR category_id IN (?)", ancestors).Find(&subscriptions).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subscriptions {
+		if product.mentionsAny(keywords(sub.Keywords)) && product.budgetInRange(sub.MinBudget, sub.MaxBudget) {
+			candidates[sub.SellerID] = true
+		}
+	}
+
+	var searches []SavedSearch
+	err = tx.Where("alert = ? AND (category_id IS NULL OR category_id IN (?))", true, ancestors).Find(&searches).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, search := range searches {
+		// The filter is matched as a whole, like the filter of listProducts
+		if search.Filter != "" && !strings.Contains(strings.ToLower(product.Title), strings.ToLower(search.Filter)) {
+			continue
+		}
+		if product.budgetInRange(search.MinBudget, search.MaxBudget) {
+			candidates[search.SellerID] = true
+		}
+	}
+
+	var sellerIDs []uint
+	for sellerID := range candidates {
+		if sellerID == product.UserID {
+			continue
+		}
+		if !canSeeProduct(tx, product, &Token{UserID: sellerID}) {
+			continue
+		}
+		sellerIDs = append(sellerIDs, sellerID)
+	}
+	return sellerIDs, nil
+}
+
+// announceProduct alerts every matching seller about a product request that
+// has just opened for offers. Each seller hears about a product at most once.
+// Failures are logged rather than returned, since the opening has succeeded.
+func announceProduct(tx *gorm.DB, product *Product) {
+	sellerIDs, err := matchingSellers(tx, product)
+	if err != nil {
+		log.Printf("alerts: failed to match sellers for product %d: %v", product.ID, err)
+		return
+	}
+
+	for _, sellerID := range sellerIDs {
+		if err := queueAlert(tx, sellerID, product); err != nil {
+			log.Printf("alerts: failed to alert seller %d about product %d: %v", sellerID, product.ID, err)
+		}
+	}
+}
+
+type subscriptionRequest struct {
+	CategoryID *uint    `json:"category_id"`
+	Keywords   string   `json:"keywords"`
+	MinBudget  *float64 `json:"min_budget"`
+	MaxBudget  *float64 `json:"max_budget"`
+}
+
+type savedSearchRequest struct {
+	Name       string   `json:"name" binding:"required"`
+	Filter     string   `json:"filter"`
+	CategoryID *uint    `json:"category_id"`
+	MinBudget  *float64 `json:"min_budget"`
+	MaxBudget  *float64 `json:"max_budget"`
+	Alert      bool     `json:"alert"`
+}
+
+// checkCriteria validates the category and budget range shared by
+// subscriptions and saved searches.
+func checkCriteria(tx *gorm.DB, categoryID *uint, min, max *float64) error {
+	if categoryID != nil {
+		var count int
+		tx.Model(&Category{}).Where("id = ?", *categoryID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("category %d does not exist", *categoryID)
+		}
+	}
+	if (min != nil && *min < 0) || (max != nil && *max < 0) {
+		return fmt.Errorf("budget range must not be negative")
+	}
+	if min != nil && max != nil && *min > *max {
+		return fmt.Errorf("min_budget must not exceed max_budget")
+	}
+	return nil
+}
+
+// @Summary Get my subscriptions
+// @Description Get the new-request subscriptions of the current seller.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} Subscription
+// @Router /profile/subscriptions [get]
+func getSubscriptions(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var subscriptions []Subscription
+	db.Where("seller_id = ?", sellerID).Order("id").Find(&subscriptions)
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// @Summary Subscribe to new product requests
+// @Description Get alerted about new product requests in a category (including its subcategories), mentioning one of the keywords and with a budget in range. Keywords are separated by commas or spaces; omitted criteria match everything.
+// @Accept json
+// @Produce json
+// @Param input body subscriptionRequest true "Subscription"
+// @Security ApiKeyAuth
+// @Success 201 {object} Subscription
+// @Router /profile/subscriptions [post]
+func subscribe(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input subscriptionRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCriteria(db, input.CategoryID, input.MinBudget, input.MaxBudget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	subscription := Subscription{
+		SellerID:   sellerID,
+		CategoryID: input.CategoryID,
+		Keywords:   strings.Join(keywords(input.Keywords), ","),
+		MinBudget:  input.MinBudget,
+		MaxBudget:  input.MaxBudget,
+		CreatedAt:  clock.Now(),
+	}
+	db.Create(&subscription)
+
+	c.JSON(http.StatusCreated, subscription)
+}
+
+// @Summary Delete a subscription
+// @Description Stop the alerts of one of the current seller's subscriptions.
+// @Accept json
+// @Produce json
+// @Param id path int true "Subscription ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /profile/subscriptions/{id} [delete]
+func unsubscribe(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	result := db.Where("id = ? AND seller_id = ?", c.Param("id"), sellerID).Delete(&Subscription{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Get my saved searches
+// @Description Get the saved searches of the current user.
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {array} SavedSearch
+// @Router /profile/searches [get]
+func getSavedSearches(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var searches []SavedSearch
+	db.Where("seller_id = ?", sellerID).Order("id").Find(&searches)
+
+	c.JSON(http.StatusOK, searches)
+}
+
+// @Summary Save a search
+// @Description Save a product search under a name. filter matches the title like the filter of the product list. With alert set, new product requests matching the search are announced.
+// @Accept json
+// @Produce json
+// @Param input body savedSearchRequest true "Search"
+// @Security ApiKeyAuth
+// @Success 201 {object} SavedSearch
+// @Router /profile/searches [post]
+func saveSearch(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input savedSearchRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCriteria(db, input.CategoryID, input.MinBudget, input.MaxBudget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	search := SavedSearch{
+		SellerID:   sellerID,
+		Name:       input.Name,
+		Filter:     input.Filter,
+		CategoryID: input.CategoryID,
+		MinBudget:  input.MinBudget,
+		MaxBudget:  input.MaxBudget,
+		Alert:      input.Alert,
+		CreatedAt:  clock.Now(),
+	}
+	db.Create(&search)
+
+	c.JSON(http.StatusCreated, search)
+}
+
+// @Summary Delete a saved search
+// @Description Delete one of the current user's saved searches.
+// @Accept json
+// @Produce json
+// @Param id path int true "Saved search ID"
+// @Security ApiKeyAuth
+// @Success 204 "No Content"
+// @Router /profile/searches/{id} [delete]
+func deleteSavedSearch(c *gin.Context) {
+	sellerID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	result := db.Where("id = ? AND seller_id = ?", c.Param("id"), sellerID).Delete(&SavedSearch{})
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Run a saved search
+// @Description Get the products currently matching one of the current user's saved searches.
+// @Accept json
+// @Produce json
+// @Param id path int true "Saved search ID"
+// @Security ApiKeyAuth
+// @Success 200 {array} Product
+// @Router /profile/searches/{id}/products [get]
+func runSavedSearch(c *gin.Context) {
+	viewer, err := viewerFromContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var search SavedSearch
+	if err := db.Where("id = ? AND seller_id = ?", c.Param("id"), viewer.UserID).First(&search).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved search not found"})
+		return
+	}
+
+	query := visibleProducts(db, viewer)
+	if search.Filter != "" {
+		query = query.Where("title LIKE ?", "%"+search.Filter+"%")
+	}
+	if search.CategoryID != nil {
+		var category Category
+		if err := db.Where("id = ?", *search.CategoryID).First(&category).Error; err == nil {
+			query = query.Where("category_id IN (?)", subtreeIDs(db, &category))
+		}
+	}
+	if search.MinBudget != nil {
+		query = query.Where("max_budget IS NULL OR max_budget >= ?", *search.MinBudget)
+	}
+	if search.MaxBudget != nil {
+		query = query.Where("max_budget IS NULL OR max_budget <= ?", *search.MaxBudget)
+	}
+
+	var products []Product
+	query.Preload("Attributes").Preload("Lots", func(db *gorm.DB) *gorm.DB {
+		return db.Order("number")
+	}).Order("id desc").Find(&products)
+
+	c.JSON(http.StatusOK, products)
+}
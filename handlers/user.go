@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -120,18 +121,18 @@ func generateToken(userID uint, isAdmin bool) (string, error) {
 }
 
 func userProfile(c *gin.Context) {
-	// Access user ID from context
-	userID, exists := c.Get("userID")
-	if !exists {
+	// Access user ID from the token claims
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "User ID not found in context"})
 		return
 	}
 
 	// Retrieve user from the database using the user ID
 	var user User
-	db.First(&user, userID.(uint))
+	db.First(&user, userID)
 
-	c.JSON(http.StatusOK, gin.H{"username": user.Username, "userID": user.ID})
+	c.JSON(http.StatusOK, gin.H{"username": user.Username, "userID": user.ID, "unread_messages": unreadMessages(db, user.ID)})
 }
 
 func authMiddleware(c *gin.Context) {
@@ -142,23 +143,42 @@ func authMiddleware(c *gin.Context) {
 		return
 	}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Token{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your-secret-key"), nil // Replace with your secret key
-	})
-
+	claims, err := parseToken(tokenString)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		c.Abort()
 		return
 	}
 
-	claims, ok := token.Claims.(*Token)
-	if !ok || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		c.Abort()
+	// Set user ID in context for handlers to access
+	c.Set("claims", claims)
+}
+
+// optionalAuthMiddleware sets the claims of a valid token like authMiddleware
+// but lets anonymous requests through, for public endpoints whose answer
+// depends on who is asking.
+func optionalAuthMiddleware(c *gin.Context) {
+	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
 		return
 	}
 
-	// Set user ID in context for handlers to access
-	c.Set("claims", claims)
+	if claims, err := parseToken(tokenString); err == nil {
+		c.Set("claims", claims)
+	}
+}
+
+func parseToken(tokenString string) (*Token, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Token{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte("your-secret-key"), nil // Replace with your secret key
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Token)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
 }
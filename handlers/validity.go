@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultValidityWindow is how far ahead a validity request looks when the
+// buyer does not say.
+const defaultValidityWindow = 24 * time.Hour
+
+// expiredAt reports whether the bid's validity has run out at t. Bids without
+// a validity never expire.
+func (b *Bid) expiredAt(t time.Time) bool {
+	return b.ValidUntil != nil && !b.ValidUntil.After(t)
+}
+
+// checkValidity refuses a validity that has already passed.
+func checkValidity(offer *Bid, now time.Time) error {
+	if offer.expiredAt(now) {
+		return &bidError{Code: "invalid_validity", Message: "valid_until must be in the future"}
+	}
+	return nil
+}
+
+// unexpiredBids drops the bids whose validity has run out at t. It is done
+// in Go because SQLite compares timestamps as text.
+func unexpiredBids(bids []Bid, t time.Time) []Bid {
+	valid := bids[:0]
+	for _, bid := range bids {
+		if !bid.expiredAt(t) {
+			valid = append(valid, bid)
+		}
+	}
+	return valid
+}
+
+type extendValidityRequest struct {
+	ValidUntil time.Time `json:"valid_until" binding:"required"`
+}
+
+// @Summary Extend the validity of an offer
+// @Description Move the valid-until time of an offer. An expired offer becomes valid again as long as the product has not been decided.
+// @Accept json
+// @Produce json
+// @Param id path int true "Offer ID"
+// @Param input body extendValidityRequest true "New validity"
+// @Security ApiKeyAuth
+// @Success 200 {object} Bid
+// @Failure 400 {object} map[string]interface{}
+// @Router /offers/{id}/validity [post]
+func extendOfferValidity(c *gin.Context) {
+	offer, product, ok := sellerOffer(c)
+	if !ok {
+		return
+	}
+
+	var input extendValidityRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := clock.Now()
+	if product.Status != Open && product.Status != Closed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offers cannot be extended on a %s product", product.Status)})
+		return
+	}
+	if offer.Outcome != BidPending || offer.IsDiscarded || offer.Disqualified {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offer is already %s", offer.Outcome)})
+		return
+	}
+	if !input.ValidUntil.After(now) {
+		respondBidError(c, &bidError{Code: "invalid_validity", Message: "valid_until must be in the future"})
+		return
+	}
+
+	tx := db.Begin()
+	err := bumpBidVersion(tx, offer, map[string]interface{}{"valid_until": input.ValidUntil})
+	if err == nil {
+		offer.ValidUntil = &input.ValidUntil
+		err = recordBidVersion(tx, offer, ChangeExtended, now)
+	}
+	if err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	tx.Commit()
+
+	c.JSON(http.StatusOK, offer)
+}
+
+type validityRequest struct {
+	WithinHours int        `json:"within_hours"`
+	ValidUntil  *time.Time `json:"valid_until"`
+}
+
+// @Summary Ask sellers to extend expiring offers
+// @Description Notify every seller whose pending offer on the product has expired or expires within within_hours, 24 by default, asking them to extend it, optionally until valid_until. Only the requester can ask.
+// @Accept json
+// @Produce json
+// @Param id path int true "Product ID"
+// @Param input body validityRequest false "Expiry window and requested validity"
+// @Security ApiKeyAuth
+// @Success 200 {array} Bid
+// @Router /products/{id}/validity-requests [post]
+func requestValidityExtension(c *gin.Context) {
+	productID := c.Param("id")
+
+	userID, err := extractSellerIDFromToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing token"})
+		return
+	}
+
+	var input validityRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if input.WithinHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "within_hours must not be negative"})
+		return
+	}
+
+	// Check if the product exists
+	var product Product
+	if err := db.Where("id = ?", productID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if product.UserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Permission denied"})
+		return
+	}
+	if product.Status != Open && product.Status != Closed {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Offers cannot be extended on a %s product", product.Status)})
+		return
+	}
+
+	window := defaultValidityWindow
+	if input.WithinHours > 0 {
+		window = time.Duration(input.WithinHours) * time.Hour
+	}
+	horizon := clock.Now().Add(window)
+
+	var pending []Bid
+	db.Where("product_id = ? AND outcome = ? AND is_discarded = ? AND disqualified = ? AND valid_until IS NOT NULL",
+		product.ID, BidPending, false, false).Order("id").Find(&pending)
+
+	message := fmt.Sprintf("The buyer of %q asks you to extend the validity of your offer", product.Title)
+	if input.ValidUntil != nil {
+		message += " until " + input.ValidUntil.Format(time.RFC3339)
+	}
+
+	var expiring []Bid
+	tx := db.Begin()
+	for i := range pending {
+		if pending[i].expiredAt(horizon) {
+			bidID := pending[i].ID
+			if err := notify(tx, pending[i].SellerID, NotifyValidityRequest, product.ID, &bidID, message); err != nil {
+				tx.Rollback()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to notify sellers"})
+				return
+			}
+			expiring = append(expiring, pending[i])
+		}
+	}
+	tx.Commit()
+
+	// Sealed offers stay hidden from the buyer even when they were asked about
+	visible, err := visibleBids(db, &product, &Token{UserID: userID}, expiring)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch offers"})
+		return
+	}
+
+	c.JSON(http.StatusOK, visible)
+}
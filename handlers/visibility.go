@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// AuctionType controls which bids and prices participants can see.
+type AuctionType string
+
+const (
+	// OpenAuction shows every bid to every participant.
+	OpenAuction AuctionType = "open"
+	// SealedAuction shows sellers only their own bids. The buyer sees all
+	// bids once the auction is closed.
+	SealedAuction AuctionType = "sealed"
+	// RankOnlyAuction shows sellers their own bids together with their
+	// current rank, but never the prices of other sellers.
+	RankOnlyAuction AuctionType = "rank_only"
+)
+
+// isBiddingPhase reports whether the product has not been closed yet.
+func (p *Product) isBiddingPhase() bool {
+	return p.Status == Draft || p.Status == PendingReview || p.Status == Open
+}
+
+// isSealed reports whether the buyer has to wait for the close to see bids.
+func (p *Product) isSealed() bool {
+	return p.AuctionType == SealedAuction || p.AuctionType == CommitRevealAuction
+}
+
+// viewerFromContext returns the claims of the authenticated user.
+func viewerFromContext(c *gin.Context) (*Token, error) {
+	claims, exists := c.Get("claims")
+	if !exists {
+		return nil, fmt.Errorf("token claims not found")
+	}
+
+	token, ok := claims.(*Token)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	return token, nil
+}
+
+// visibleBids filters bids down to what viewer may see on product and fills in
+// ranks where the auction type calls for them. Every endpoint that returns
+// bids must pass them through here.
+func visibleBids(tx *gorm.DB, product *Product, viewer *Token, bids []Bid) ([]Bid, error) {
+	if viewer.IsAdmin || product.AuctionType == OpenAuction || product.AuctionType == "" {
+		return bids, nil
+	}
+
+	if viewer.UserID == product.UserID {
+		if product.isSealed() && product.isBiddingPhase() {
+			return []Bid{}, nil
+		}
+		return bids, nil
+	}
+
+	// Scores are relative to the other bids, so sellers do not get them
+	own := []Bid{}
+	for _, bid := range bids {
+		if bid.SellerID == viewer.UserID {
+			bid.Score = 0
+			own = append(own, bid)
+		}
+	}
+
+	// Ranks are counted within the lot the bid is for
+	if product.AuctionType == RankOnlyAuction {
+		for i := range own {
+			ranked, err := rankedBids(tx, product, own[i].LotID, clock.Now())
+			if err != nil {
+				return nil, err
+			}
+			own[i].Rank = rankOf(ranked, own[i].ID)
+		}
+	}
+
+	return own, nil
+}
+
+// rankOf returns the 1-based position of the bid in ranked, or zero when the
+// bid is not ranked.
+func rankOf(ranked []Bid, bidID uint) int {
+	for i, bid := range ranked {
+		if bid.ID == bidID {
+			return i + 1
+		}
+	}
+	return 0
+}
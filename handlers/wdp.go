@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"sort"
+	"time"
+)
+
+// wdpBid is a candidate of winner determination: a single-lot or bundle bid
+// with the lots it covers and its total cost to the buyer.
+type wdpBid struct {
+	BidID uint
+	Lots  []uint
+	Cost  float64
+}
+
+// wdpResult is the chosen set of non-overlapping bids. Lots no bid could
+// cover are listed in Uncovered. Exact is false when the search hit its time
+// limit and the result may not be optimal.
+type wdpResult struct {
+	BidIDs    []uint
+	Uncovered []uint
+	Cost      float64
+	Method    string
+	Exact     bool
+}
+
+// solveWinners picks non-overlapping bids that cover as many lots as possible
+// at the lowest total cost. It starts from a greedy solution and improves it
+// with an exact branch-and-bound search until deadline.
+func solveWinners(lotIDs []uint, bids []wdpBid, deadline time.Time) wdpResult {
+	s := newWDPSolver(lotIDs, bids, deadline)
+
+	s.greedy()
+	s.search(0, 0, 0)
+
+	result := wdpResult{Cost: s.bestCost, Exact: !s.timedOut, Method: "branch_and_bound"}
+	if s.timedOut {
+		result.Method = "heuristic"
+	}
+
+	covered := make([]bool, len(lotIDs))
+	for _, b := range s.best {
+		result.BidIDs = append(result.BidIDs, s.bids[b].BidID)
+		for _, lot := range s.bids[b].lots {
+			covered[lot] = true
+		}
+	}
+	sort.Slice(result.BidIDs, func(i, j int) bool { return result.BidIDs[i] < result.BidIDs[j] })
+	for i, id := range lotIDs {
+		if !covered[i] {
+			result.Uncovered = append(result.Uncovered, id)
+		}
+	}
+	return result
+}
+
+type wdpCandidate struct {
+	BidID uint
+	lots  []int
+	cost  float64
+	share float64
+}
+
+type wdpSolver struct {
+	bids     []wdpCandidate
+	byLot    [][]int
+	share    []float64
+	decided  []bool
+	chosen   []int
+	best     []int
+	bestUnc  int
+	bestCost float64
+	deadline time.Time
+	nodes    int
+	timedOut bool
+}
+
+func newWDPSolver(lotIDs []uint, bids []wdpBid, deadline time.Time) *wdpSolver {
+	index := map[uint]int{}
+	for i, id := range lotIDs {
+		index[id] = i
+	}
+
+	s := &wdpSolver{
+		byLot:    make([][]int, len(lotIDs)),
+		share:    make([]float64, len(lotIDs)),
+		decided:  make([]bool, len(lotIDs)),
+		bestUnc:  len(lotIDs) + 1,
+		deadline: deadline,
+	}
+
+	for _, bid := range bids {
+		candidate := wdpCandidate{BidID: bid.BidID, cost: bid.Cost}
+		for _, id := range bid.Lots {
+			if i, ok := index[id]; ok {
+				candidate.lots = append(candidate.lots, i)
+			}
+		}
+		if len(candidate.lots) == 0 || len(candidate.lots) != len(bid.Lots) {
+			continue
+		}
+		candidate.share = bid.Cost / float64(len(candidate.lots))
+		s.bids = append(s.bids, candidate)
+	}
+
+	// Try the cheapest bids per lot first so good solutions are found early
+	sort.SliceStable(s.bids, func(i, j int) bool {
+		if s.bids[i].share != s.bids[j].share {
+			return s.bids[i].share < s.bids[j].share
+		}
+		return s.bids[i].BidID < s.bids[j].BidID
+	})
+	for b, bid := range s.bids {
+		for _, lot := range bid.lots {
+			if len(s.byLot[lot]) == 0 {
+				s.share[lot] = bid.share
+			}
+			s.byLot[lot] = append(s.byLot[lot], b)
+		}
+	}
+	return s
+}
+
+// greedy takes bids in order of cost per lot while they do not overlap and
+// records the result as the first incumbent.
+func (s *wdpSolver) greedy() {
+	taken := make([]bool, len(s.byLot))
+	var chosen []int
+	cost := 0.0
+	for b, bid := range s.bids {
+		if s.overlaps(bid, taken) {
+			continue
+		}
+		for _, lot := range bid.lots {
+			taken[lot] = true
+		}
+		chosen = append(chosen, b)
+		cost += bid.cost
+	}
+
+	uncovered := 0
+	for _, t := range taken {
+		if !t {
+			uncovered++
+		}
+	}
+	s.record(chosen, uncovered, cost)
+}
+
+// search branches on the first undecided lot: it is either covered by one of
+// the bids that fit, or left uncovered.
+func (s *wdpSolver) search(start, uncovered int, cost float64) {
+	if s.timedOut {
+		return
+	}
+	s.nodes++
+	if s.nodes%1024 == 0 && time.Now().After(s.deadline) {
+		s.timedOut = true
+		return
+	}
+
+	lot := start
+	for lot < len(s.decided) && s.decided[lot] {
+		lot++
+	}
+	if lot == len(s.decided) {
+		s.record(s.chosen, uncovered, cost)
+		return
+	}
+
+	// Bound: undecided lots without any bid stay uncovered, and every other
+	// one costs at least the cheapest share of a bid covering it
+	boundUnc, boundCost := uncovered, cost
+	for i := lot; i < len(s.decided); i++ {
+		if s.decided[i] {
+			continue
+		}
+		if len(s.byLot[i]) == 0 {
+			boundUnc++
+		} else {
+			boundCost += s.share[i]
+		}
+	}
+	if boundUnc > s.bestUnc || (boundUnc == s.bestUnc && boundCost >= s.bestCost-1e-9) {
+		return
+	}
+
+	for _, b := range s.byLot[lot] {
+		bid := s.bids[b]
+		if s.overlaps(bid, s.decided) {
+			continue
+		}
+		for _, l := range bid.lots {
+			s.decided[l] = true
+		}
+		s.chosen = append(s.chosen, b)
+
+		s.search(lot+1, uncovered, cost+bid.cost)
+
+		s.chosen = s.chosen[:len(s.chosen)-1]
+		for _, l := range bid.lots {
+			s.decided[l] = false
+		}
+	}
+
+	s.decided[lot] = true
+	s.search(lot+1, uncovered+1, cost)
+	s.decided[lot] = false
+}
+
+func (s *wdpSolver) overlaps(bid wdpCandidate, taken []bool) bool {
+	for _, lot := range bid.lots {
+		if taken[lot] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *wdpSolver) record(chosen []int, uncovered int, cost float64) {
+	if uncovered < s.bestUnc || (uncovered == s.bestUnc && cost < s.bestCost-1e-9) {
+		s.best = append([]int(nil), chosen...)
+		s.bestUnc = uncovered
+		s.bestCost = cost
+	}
+}
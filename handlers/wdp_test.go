@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// bruteForceWinners tries every subset of bids and returns the fewest lots
+// left uncovered and the lowest cost among the subsets that achieve it.
+func bruteForceWinners(lotIDs []uint, bids []wdpBid) (int, float64) {
+	bestUnc, bestCost := len(lotIDs)+1, math.Inf(1)
+	for mask := 0; mask < 1<<len(bids); mask++ {
+		taken := map[uint]bool{}
+		cost, overlap := 0.0, false
+		for i, bid := range bids {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			for _, lot := range bid.Lots {
+				if taken[lot] {
+					overlap = true
+				}
+				taken[lot] = true
+			}
+			cost += bid.Cost
+		}
+		if overlap {
+			continue
+		}
+		uncovered := len(lotIDs) - len(taken)
+		if uncovered < bestUnc || (uncovered == bestUnc && cost < bestCost) {
+			bestUnc, bestCost = uncovered, cost
+		}
+	}
+	return bestUnc, bestCost
+}
+
+// randomAuction builds lots with single-lot bids and bundles over random
+// subsets of them.
+func randomAuction(r *rand.Rand, lots, singles, bundles int) ([]uint, []wdpBid) {
+	lotIDs := make([]uint, lots)
+	for i := range lotIDs {
+		lotIDs[i] = uint(i + 1)
+	}
+
+	var bids []wdpBid
+	id := uint(1)
+	for i := 0; i < singles; i++ {
+		lot := lotIDs[r.Intn(lots)]
+		bids = append(bids, wdpBid{BidID: id, Lots: []uint{lot}, Cost: float64(10 + r.Intn(90))})
+		id++
+	}
+	for i := 0; i < bundles; i++ {
+		var covered []uint
+		for _, lot := range lotIDs {
+			if r.Intn(3) == 0 {
+				covered = append(covered, lot)
+			}
+		}
+		if len(covered) < 2 {
+			continue
+		}
+		bids = append(bids, wdpBid{BidID: id, Lots: covered, Cost: float64(len(covered) * (10 + r.Intn(90)))})
+		id++
+	}
+	return lotIDs, bids
+}
+
+func TestSolveWinnersMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		lotIDs, bids := randomAuction(r, 2+r.Intn(5), r.Intn(8), r.Intn(6))
+
+		wantUnc, wantCost := bruteForceWinners(lotIDs, bids)
+		got := solveWinners(lotIDs, bids, time.Now().Add(time.Minute))
+
+		if !got.Exact || got.Method != "branch_and_bound" {
+			t.Fatalf("case %d: result not exact: %+v", i, got)
+		}
+		if len(got.Uncovered) != wantUnc || math.Abs(got.Cost-wantCost) > 1e-9 {
+			t.Fatalf("case %d: got %d uncovered at %g, want %d at %g\nbids: %+v",
+				i, len(got.Uncovered), got.Cost, wantUnc, wantCost, bids)
+		}
+	}
+}
+
+func TestSolveWinners(t *testing.T) {
+	tests := []struct {
+		name      string
+		lots      []uint
+		bids      []wdpBid
+		winners   []uint
+		uncovered []uint
+		cost      float64
+	}{
+		{
+			name: "bundle beats the single bids it overlaps",
+			lots: []uint{1, 2, 3},
+			bids: []wdpBid{
+				{BidID: 1, Lots: []uint{1}, Cost: 40},
+				{BidID: 2, Lots: []uint{2}, Cost: 40},
+				{BidID: 3, Lots: []uint{3}, Cost: 40},
+				{BidID: 4, Lots: []uint{1, 2}, Cost: 70},
+				{BidID: 5, Lots: []uint{2, 3}, Cost: 60},
+			},
+			winners: []uint{1, 5},
+			cost:    100,
+		},
+		{
+			name: "overlapping bundles are never combined",
+			lots: []uint{1, 2, 3},
+			bids: []wdpBid{
+				{BidID: 1, Lots: []uint{1, 2}, Cost: 10},
+				{BidID: 2, Lots: []uint{2, 3}, Cost: 10},
+				{BidID: 3, Lots: []uint{3}, Cost: 50},
+			},
+			winners: []uint{1, 3},
+			cost:    60,
+		},
+		{
+			name: "lots without bids stay uncovered",
+			lots: []uint{1, 2, 3},
+			bids: []wdpBid{
+				{BidID: 1, Lots: []uint{1}, Cost: 10},
+				{BidID: 2, Lots: []uint{3}, Cost: 20},
+			},
+			winners:   []uint{1, 2},
+			uncovered: []uint{2},
+			cost:      30,
+		},
+		{
+			name: "covering more lots beats a lower cost",
+			lots: []uint{1, 2},
+			bids: []wdpBid{
+				{BidID: 1, Lots: []uint{1}, Cost: 10},
+				{BidID: 2, Lots: []uint{1, 2}, Cost: 500},
+			},
+			winners: []uint{2},
+			cost:    500,
+		},
+		{
+			name: "equal bids on a lot go to the lower bid ID",
+			lots: []uint{1},
+			bids: []wdpBid{
+				{BidID: 7, Lots: []uint{1}, Cost: 25},
+				{BidID: 3, Lots: []uint{1}, Cost: 25},
+			},
+			winners: []uint{3},
+			cost:    25,
+		},
+		{
+			// Solutions are tried cheapest per lot and lowest ID first, and
+			// only a strictly cheaper one replaces what was found
+			name: "equal-cost solutions keep the first one found",
+			lots: []uint{1, 2},
+			bids: []wdpBid{
+				{BidID: 7, Lots: []uint{1}, Cost: 25},
+				{BidID: 3, Lots: []uint{1}, Cost: 25},
+				{BidID: 9, Lots: []uint{2}, Cost: 25},
+				{BidID: 4, Lots: []uint{1, 2}, Cost: 50},
+			},
+			winners: []uint{3, 9},
+			cost:    50,
+		},
+		{
+			name: "bids on unknown lots are ignored",
+			lots: []uint{1},
+			bids: []wdpBid{
+				{BidID: 1, Lots: []uint{1, 99}, Cost: 1},
+				{BidID: 2, Lots: []uint{1}, Cost: 30},
+			},
+			winners: []uint{2},
+			cost:    30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := solveWinners(tt.lots, tt.bids, time.Now().Add(time.Minute))
+			if !reflect.DeepEqual(got.BidIDs, tt.winners) || !reflect.DeepEqual(got.Uncovered, tt.uncovered) || got.Cost != tt.cost {
+				t.Errorf("got winners %v, uncovered %v at %g; want %v, %v at %g",
+					got.BidIDs, got.Uncovered, got.Cost, tt.winners, tt.uncovered, tt.cost)
+			}
+			if !got.Exact {
+				t.Error("result should be exact")
+			}
+		})
+	}
+}
+
+func TestSolveWinnersFallsBackToHeuristic(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	lotIDs, bids := randomAuction(r, 24, 96, 60)
+
+	// A deadline in the past stops the search at its first time check
+	got := solveWinners(lotIDs, bids, time.Now().Add(-time.Second))
+	if got.Exact || got.Method != "heuristic" {
+		t.Fatalf("got method %q exact %v, want the heuristic", got.Method, got.Exact)
+	}
+
+	// The greedy incumbent is still a valid, non-overlapping award
+	costs := map[uint]wdpBid{}
+	for _, bid := range bids {
+		costs[bid.BidID] = bid
+	}
+	taken := map[uint]bool{}
+	total := 0.0
+	for _, id := range got.BidIDs {
+		for _, lot := range costs[id].Lots {
+			if taken[lot] {
+				t.Fatalf("lot %d is awarded twice", lot)
+			}
+			taken[lot] = true
+		}
+		total += costs[id].Cost
+	}
+	if math.Abs(total-got.Cost) > 1e-9 || len(taken)+len(got.Uncovered) != len(lotIDs) {
+		t.Errorf("result %+v is inconsistent with its bids", got)
+	}
+}